@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+)
+
+// NewClientConfig Allowlist holds MAC prefixes of expected clients and
+// Networks the SSIDs or wired networks new clients are reported on, all of
+// them when it is empty. Known clients are forgotten once they did not connect
+// for KnownTTL, the least recently connected first above KnownMax.
+type NewClientConfig struct {
+	Enabled   bool          `env:"NEW_CLIENT_ENABLED"`
+	Allowlist []string      `env:"NEW_CLIENT_ALLOWLIST" envSeparator:","`
+	Networks  []string      `env:"NEW_CLIENT_NETWORKS" envSeparator:","`
+	KnownTTL  time.Duration `env:"NEW_CLIENT_KNOWN_TTL" envDefault:"2160h"`
+	KnownMax  int           `env:"NEW_CLIENT_KNOWN_MAX" envDefault:"10000"`
+}
+
+// EventKeyNewClient is the key of the event synthesized when a client is seen
+// for the first time.
+const EventKeyNewClient = "EVT_UN_NewClient"
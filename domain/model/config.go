@@ -1,15 +1,28 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env"
 )
 
 type AppConfig struct {
 	CheckInterval        int      `env:"CHECK_INTERVAL" envDefault:"1"`
-	NotificationServices []string `env:"NOTIFCATION_SERVICES,required" envSeparator:","`
+	NotificationServices []string `env:"NOTIFCATION_SERVICES" envSeparator:","`
+	ControllersFile      string   `env:"UNIFI_CONTROLLERS_FILE"`
+}
+
+type StateConfig struct {
+	File         string        `env:"STATE_FILE"`
+	MaxCatchUp   time.Duration `env:"STATE_MAX_CATCH_UP" envDefault:"1h"`
+	DedupTTL     time.Duration `env:"DEDUP_TTL" envDefault:"2h"`
+	DedupMaxIDs  int           `env:"DEDUP_MAX_IDS" envDefault:"10000"`
+	DedupOverlap time.Duration `env:"DEDUP_OVERLAP" envDefault:"5m"`
 }
 
 type LoggerConfig struct {
@@ -17,10 +30,28 @@ type LoggerConfig struct {
 }
 
 type UnifiConfig struct {
-	URL      string   `env:"UNIFI_URL,required"`
-	Sites    []string `env:"UNIFI_SITES,required" envSeparator:","`
-	Username string   `env:"UNIFI_USERNAME,required"`
-	Password string   `env:"UNIFI_PASSWORD,required"`
+	Name     string   `env:"UNIFI_NAME" json:"name"`
+	URL      string   `env:"UNIFI_URL" json:"url"`
+	Sites    []string `env:"UNIFI_SITES" envSeparator:"," json:"sites"`
+	Username string   `env:"UNIFI_USERNAME" json:"username"`
+	Password string   `env:"UNIFI_PASSWORD" json:"password"`
+	APIKey   string   `env:"UNIFI_API_KEY" json:"api_key"`
+
+	CAFile             string   `env:"UNIFI_CA_FILE" json:"ca_file"`
+	CertPins           []string `env:"UNIFI_CERT_PINS" envSeparator:"," json:"cert_pins"`
+	ClientCertFile     string   `env:"UNIFI_CLIENT_CERT_FILE" json:"client_cert_file"`
+	ClientKeyFile      string   `env:"UNIFI_CLIENT_KEY_FILE" json:"client_key_file"`
+	InsecureSkipVerify bool     `env:"UNIFI_INSECURE_SKIP_VERIFY" json:"insecure_skip_verify"`
+
+	SiteDiscovery         bool          `env:"UNIFI_SITE_DISCOVERY" json:"site_discovery"`
+	SiteDiscoveryInterval time.Duration `env:"UNIFI_SITE_DISCOVERY_INTERVAL" envDefault:"10m" json:"-"`
+	SitesInclude          []string      `env:"UNIFI_SITES_INCLUDE" envSeparator:"," json:"sites_include"`
+	SitesExclude          []string      `env:"UNIFI_SITES_EXCLUDE" envSeparator:"," json:"sites_exclude"`
+
+	Stream           bool          `env:"UNIFI_STREAM" json:"stream"`
+	StreamMaxBackoff time.Duration `env:"UNIFI_STREAM_MAX_BACKOFF" envDefault:"5m" json:"-"`
+
+	InventoryRefreshInterval time.Duration `env:"UNIFI_INVENTORY_REFRESH_INTERVAL" envDefault:"15m" json:"-"`
 }
 
 type SlackConfig struct {
@@ -28,14 +59,75 @@ type SlackConfig struct {
 	EventsWebhook string `env:"SLACK_EVENTS_WEBHOOK,required"`
 }
 
-func NewConfig() (AppConfig, LoggerConfig, UnifiConfig, SlackConfig, error) {
+type Config struct {
+	App      AppConfig
+	State    StateConfig
+	Name     NameConfig
+	Host     HostConfig
+	Client   NewClientConfig
+	GeoIP    GeoIPConfig
+	Severity SeverityConfig
+	Filter   FilterConfig
+	Route    RouteConfig
+	Mention  MentionConfig
+	Template TemplateConfig
+	Digest   DigestConfig
+	Throttle ThrottleConfig
+	Flap     FlapConfig
+	Monitor  DeviceMonitorConfig
+	Health   HealthConfig
+	WAN      WANMonitorConfig
+	Firmware FirmwareConfig
+	Schedule ScheduleConfig
+	Logger   LoggerConfig
+	Unifi    []UnifiConfig
+	Slack    SlackConfig
+}
+
+func NewConfig() (Config, error) {
 	appConfig := AppConfig{}
+	stateConfig := StateConfig{}
+	nameConfig := NameConfig{}
+	hostConfig := HostConfig{}
+	clientConfig := NewClientConfig{}
+	geoIPConfig := GeoIPConfig{}
+	severityConfig := SeverityConfig{}
+	filterConfig := FilterConfig{}
+	routeConfig := RouteConfig{}
+	mentionConfig := MentionConfig{}
+	templateConfig := TemplateConfig{}
+	digestConfig := DigestConfig{}
+	throttleConfig := ThrottleConfig{}
+	flapConfig := FlapConfig{}
+	monitorConfig := DeviceMonitorConfig{}
+	healthConfig := HealthConfig{}
+	wanConfig := WANMonitorConfig{}
+	firmwareConfig := FirmwareConfig{}
+	scheduleConfig := ScheduleConfig{}
 	loggerConfig := LoggerConfig{}
 	unifiConfig := UnifiConfig{}
 	slackConfig := SlackConfig{}
 	var errs []string
 	for _, e := range []error{
 		env.Parse(&appConfig),
+		env.Parse(&stateConfig),
+		env.Parse(&nameConfig),
+		env.Parse(&hostConfig),
+		env.Parse(&clientConfig),
+		env.Parse(&geoIPConfig),
+		env.Parse(&severityConfig),
+		env.Parse(&filterConfig),
+		env.Parse(&routeConfig),
+		env.Parse(&mentionConfig),
+		env.Parse(&templateConfig),
+		env.Parse(&digestConfig),
+		env.Parse(&throttleConfig),
+		env.Parse(&flapConfig),
+		env.Parse(&monitorConfig),
+		env.Parse(&healthConfig),
+		env.Parse(&wanConfig),
+		env.Parse(&firmwareConfig),
+		env.Parse(&scheduleConfig),
 		env.Parse(&loggerConfig),
 		env.Parse(&unifiConfig),
 	} {
@@ -44,6 +136,49 @@ func NewConfig() (AppConfig, LoggerConfig, UnifiConfig, SlackConfig, error) {
 		}
 	}
 
+	unifiConfigs := []UnifiConfig{unifiConfig}
+	if appConfig.ControllersFile != "" {
+		controllers, err := newUnifiConfigs(appConfig.ControllersFile, unifiConfig)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		unifiConfigs = controllers
+	}
+
+	names := map[string]bool{}
+	for _, unifiConfig := range unifiConfigs {
+		unifiErrs := validateUnifiConfig(unifiConfig)
+		if appConfig.ControllersFile != "" {
+			if unifiConfig.Name == "" {
+				unifiErrs = append(unifiErrs, "name is required")
+			} else if names[unifiConfig.Name] {
+				unifiErrs = append(unifiErrs, "name must be unique")
+			}
+			names[unifiConfig.Name] = true
+			for i, e := range unifiErrs {
+				unifiErrs[i] = fmt.Sprintf("controller %q: %s", unifiConfig.Name, e)
+			}
+		}
+		errs = append(errs, unifiErrs...)
+	}
+
+	if scheduleConfig.AdminListenAddr != "" && scheduleConfig.AdminToken == "" {
+		errs = append(errs, "ADMIN_TOKEN is required when ADMIN_LISTEN_ADDR is set")
+	}
+
+	// an ID pruned before the catch up window ends would be notified again
+	if stateConfig.DedupTTL < stateConfig.MaxCatchUp {
+		errs = append(errs, "DEDUP_TTL must be at least STATE_MAX_CATCH_UP")
+	}
+
+	if clientConfig.Enabled && clientConfig.KnownTTL <= 0 {
+		errs = append(errs, "NEW_CLIENT_KNOWN_TTL must be positive")
+	}
+
+	if len(appConfig.NotificationServices) == 0 && routeConfig.File == "" {
+		errs = append(errs, "NOTIFCATION_SERVICES is required when ROUTES_FILE is not set")
+	}
+
 	for _, notificationService := range appConfig.NotificationServices {
 		if notificationService == "slack" {
 			err := env.Parse(&slackConfig)
@@ -57,5 +192,81 @@ func NewConfig() (AppConfig, LoggerConfig, UnifiConfig, SlackConfig, error) {
 	if len(errs) > 0 {
 		err = errors.New(strings.Join(errs, ", "))
 	}
-	return appConfig, loggerConfig, unifiConfig, slackConfig, err
+	config := Config{
+		App:      appConfig,
+		State:    stateConfig,
+		Name:     nameConfig,
+		Host:     hostConfig,
+		Client:   clientConfig,
+		GeoIP:    geoIPConfig,
+		Severity: severityConfig,
+		Filter:   filterConfig,
+		Route:    routeConfig,
+		Mention:  mentionConfig,
+		Template: templateConfig,
+		Digest:   digestConfig,
+		Throttle: throttleConfig,
+		Flap:     flapConfig,
+		Monitor:  monitorConfig,
+		Health:   healthConfig,
+		WAN:      wanConfig,
+		Firmware: firmwareConfig,
+		Schedule: scheduleConfig,
+		Logger:   loggerConfig,
+		Unifi:    unifiConfigs,
+		Slack:    slackConfig,
+	}
+	return config, err
+}
+
+// newUnifiConfigs reads the list of controllers from a JSON file, settings a
+// controller leaves out are taken from the UNIFI_* environment variables.
+func newUnifiConfigs(path string, defaults UnifiConfig) ([]UnifiConfig, error) {
+	controllersBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []UnifiConfig{}, err
+	}
+
+	controllers := []json.RawMessage{}
+	err = json.Unmarshal(controllersBytes, &controllers)
+	if err != nil {
+		return []UnifiConfig{}, fmt.Errorf("could not parse controllers file %s, error=%s", path, err)
+	}
+
+	unifiConfigs := []UnifiConfig{}
+	for _, controller := range controllers {
+		unifiConfig := defaults
+		// json.Unmarshal reuses the backing arrays of slices, copy them so a
+		// controller setting them does not change the defaults of the next
+		unifiConfig.Sites = append([]string(nil), defaults.Sites...)
+		unifiConfig.CertPins = append([]string(nil), defaults.CertPins...)
+		unifiConfig.SitesInclude = append([]string(nil), defaults.SitesInclude...)
+		unifiConfig.SitesExclude = append([]string(nil), defaults.SitesExclude...)
+		err = json.Unmarshal(controller, &unifiConfig)
+		if err != nil {
+			return []UnifiConfig{}, fmt.Errorf("could not parse controllers file %s, error=%s", path, err)
+		}
+		unifiConfigs = append(unifiConfigs, unifiConfig)
+	}
+
+	if len(unifiConfigs) == 0 {
+		return []UnifiConfig{}, fmt.Errorf("no controllers found in controllers file %s", path)
+	}
+	return unifiConfigs, nil
+}
+
+func validateUnifiConfig(unifiConfig UnifiConfig) []string {
+	var errs []string
+	if unifiConfig.URL == "" {
+		errs = append(errs, "UNIFI_URL is required")
+	}
+
+	if !unifiConfig.SiteDiscovery && len(unifiConfig.Sites) == 0 {
+		errs = append(errs, "UNIFI_SITES is required when UNIFI_SITE_DISCOVERY is not enabled")
+	}
+
+	if unifiConfig.APIKey == "" && (unifiConfig.Username == "" || unifiConfig.Password == "") {
+		errs = append(errs, "UNIFI_USERNAME and UNIFI_PASSWORD are required when UNIFI_API_KEY is not set")
+	}
+	return errs
 }
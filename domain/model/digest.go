@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+)
+
+type DigestConfig struct {
+	Enabled       bool          `env:"DIGEST_ENABLED"`
+	Window        time.Duration `env:"DIGEST_WINDOW" envDefault:"1h"`
+	Keys          []string      `env:"DIGEST_KEYS" envSeparator:","`
+	ImmediateKeys []string      `env:"DIGEST_IMMEDIATE_KEYS" envSeparator:","`
+	Top           int           `env:"DIGEST_TOP" envDefault:"5"`
+}
+
+// Keys of the events this application synthesizes itself rather than reads
+// from the controller.
+const (
+	SyntheticSubsystem     = "notifications"
+	EventKeyDigest         = "EVT_UN_Digest"
+	EventKeySuppressed     = "EVT_UN_Suppressed"
+	EventKeyFloodDetected  = "EVT_UN_FloodDetected"
+	EventKeyFloodRecovered = "EVT_UN_FloodRecovered"
+	EventKeyDeviceFlapping = "EVT_UN_DeviceFlapping"
+)
@@ -0,0 +1,47 @@
+package model
+
+type FilterConfig struct {
+	RulesFile string `env:"FILTER_RULES_FILE"`
+}
+
+const (
+	FilterActionInclude = "include"
+	FilterActionExclude = "exclude"
+)
+
+type FilterRule struct {
+	Name    string  `json:"name"`
+	Action  string  `json:"action"`
+	Matcher Matcher `json:"match"`
+}
+
+// Matcher matches alarms and events. Every criteria that is set must match, a
+// criteria with several values matches when any of them does. Sites, keys,
+// subsystems, categories, MACs and SSIDs are glob patterns.
+type Matcher struct {
+	Types                []string    `json:"types"`
+	Controllers          []string    `json:"controllers"`
+	Sites                []string    `json:"sites"`
+	Keys                 []string    `json:"keys"`
+	Subsystems           []string    `json:"subsystems"`
+	Catnames             []string    `json:"catnames"`
+	InnerAlertSeverities []int64     `json:"inner_alert_severities"`
+	Severities           []Severity  `json:"severities"`
+	MACs                 []string    `json:"macs"`
+	CIDRs                []string    `json:"cidrs"`
+	SSIDs                []string    `json:"ssids"`
+	Countries            []string    `json:"countries"`
+	ASNs                 []uint      `json:"asns"`
+	Msg                  string      `json:"msg"`
+	TimeOfDay            *TimeWindow `json:"time_of_day"`
+}
+
+// TimeWindow is a daily window from Start to End as HH:MM in Timezone, the
+// window wraps past midnight when End is before Start. Days limits the window
+// to weekdays such as mon or tue, every day when empty.
+type TimeWindow struct {
+	Days     []string `json:"days"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Timezone string   `json:"timezone"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// FirmwareConfig the summary of outdated devices is sent every week on
+// SummaryDay at SummaryTime, an empty SummaryDay disables it.
+type FirmwareConfig struct {
+	Enabled     bool          `env:"FIRMWARE_MONITOR_ENABLED"`
+	Interval    time.Duration `env:"FIRMWARE_MONITOR_INTERVAL" envDefault:"5m"`
+	Timeout     time.Duration `env:"FIRMWARE_UPGRADE_TIMEOUT" envDefault:"30m"`
+	SummaryDay  string        `env:"FIRMWARE_SUMMARY_DAY" envDefault:"mon"`
+	SummaryTime string        `env:"FIRMWARE_SUMMARY_TIME" envDefault:"09:00"`
+}
+
+// Keys of the events synthesized from firmware versions and upgrades.
+const (
+	EventKeyUpgradeStarted   = "EVT_UN_UpgradeStarted"
+	EventKeyUpgradeCompleted = "EVT_UN_UpgradeCompleted"
+	EventKeyUpgradeFailed    = "EVT_UN_UpgradeFailed"
+	EventKeyControllerUpdate = "EVT_UN_ControllerUpdateAvailable"
+	EventKeyFirmwareSummary  = "EVT_UN_FirmwareSummary"
+)
+
+type UnifiSysinfo struct {
+	Meta    Meta               `json:"meta"`
+	Sysinfo []UnifiSysinfoData `json:"data"`
+}
+
+type UnifiSysinfoData struct {
+	Version          string `json:"version"`
+	Build            string `json:"build"`
+	Hostname         string `json:"hostname"`
+	UpdateAvailable  bool   `json:"update_available"`
+	UpdateDownloaded bool   `json:"update_downloaded"`
+}
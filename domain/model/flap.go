@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+)
+
+type FlapConfig struct {
+	Enabled   bool          `env:"FLAP_ENABLED"`
+	Grace     time.Duration `env:"FLAP_GRACE" envDefault:"2m"`
+	Window    time.Duration `env:"FLAP_WINDOW" envDefault:"30m"`
+	Threshold int           `env:"FLAP_THRESHOLD" envDefault:"3"`
+}
+
+// Keys of the controller events for devices losing and regaining contact.
+const (
+	EventKeyAPLostContact = "EVT_AP_Lost_Contact"
+	EventKeyAPConnected   = "EVT_AP_Connected"
+	EventKeySWLostContact = "EVT_SW_Lost_Contact"
+	EventKeySWConnected   = "EVT_SW_Connected"
+	EventKeyGWLostContact = "EVT_GW_Lost_Contact"
+	EventKeyGWConnected   = "EVT_GW_Connected"
+)
@@ -0,0 +1,34 @@
+package model
+
+// GeoIPConfig databases are MaxMind format mmdb files such as GeoLite2-City
+// and GeoLite2-ASN.
+type GeoIPConfig struct {
+	CityDB string `env:"GEOIP_CITY_DB"`
+	ASNDB  string `env:"GEOIP_ASN_DB"`
+}
+
+// GeoIP is the location and network of the remote address of an alarm or
+// event.
+type GeoIP struct {
+	IP          string `json:"ip"`
+	Country     string `json:"country,omitempty"`
+	CountryName string `json:"country_name,omitempty"`
+	City        string `json:"city,omitempty"`
+	ASN         uint   `json:"asn,omitempty"`
+	ASOrg       string `json:"as_org,omitempty"`
+}
+
+type GeoIPCityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+}
+
+type GeoIPASNRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
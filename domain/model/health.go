@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// HealthConfig thresholds of zero are not checked.
+type HealthConfig struct {
+	Enabled          bool          `env:"HEALTH_MONITOR_ENABLED"`
+	Interval         time.Duration `env:"HEALTH_MONITOR_INTERVAL" envDefault:"1m"`
+	Subsystems       []string      `env:"HEALTH_SUBSYSTEMS" envSeparator:"," envDefault:"wan,www,wlan,lan,vpn"`
+	LatencyThreshold time.Duration `env:"HEALTH_LATENCY_THRESHOLD" envDefault:"250ms"`
+	DropsThreshold   int64         `env:"HEALTH_DROPS_THRESHOLD"`
+}
+
+// Statuses of a subsystem in stat/health.
+const (
+	HealthStatusOK      = "ok"
+	HealthStatusWarning = "warning"
+	HealthStatusError   = "error"
+)
+
+// Keys of the events synthesized from site health changes.
+const (
+	EventKeyHealthDegraded  = "EVT_UN_HealthDegraded"
+	EventKeyHealthRecovered = "EVT_UN_HealthRecovered"
+)
+
+type UnifiSiteHealth map[string]UnifiHealth
+
+type UnifiHealth struct {
+	Meta            Meta                   `json:"meta"`
+	Subsystems      []UnifiSubsystemHealth `json:"data"`
+	SiteDescription string                 `json:"-"`
+	Controller      string                 `json:"-"`
+}
+
+type UnifiSubsystemHealth struct {
+	Subsystem       string `json:"subsystem"`
+	Status          string `json:"status"`
+	Latency         int64  `json:"latency"`
+	Drops           int64  `json:"drops"`
+	WanIP           string `json:"wan_ip"`
+	IspName         string `json:"isp_name"`
+	GwMac           string `json:"gw_mac"`
+	GwName          string `json:"gw_name"`
+	NumDisconnected int64  `json:"num_disconnected"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+const (
+	HostNamesAnnotate = "annotate"
+	HostNamesReplace  = "replace"
+)
+
+// HostConfig ReverseDNSResolver is the host:port of the DNS server used for
+// reverse lookups, the system resolver is used when it is empty.
+type HostConfig struct {
+	Names              string        `env:"HOST_NAMES" envDefault:"annotate"`
+	ReverseDNS         bool          `env:"REVERSE_DNS"`
+	ReverseDNSResolver string        `env:"REVERSE_DNS_RESOLVER"`
+	ReverseDNSTimeout  time.Duration `env:"REVERSE_DNS_TIMEOUT" envDefault:"2s"`
+	ReverseDNSCacheTTL time.Duration `env:"REVERSE_DNS_CACHE_TTL" envDefault:"1h"`
+}
@@ -0,0 +1,34 @@
+package model
+
+// MentionConfig without a mentions file, or when no rule matches, alarms and
+// events of at least MinSeverity get the Default mention.
+type MentionConfig struct {
+	File        string   `env:"MENTIONS_FILE"`
+	Default     string   `env:"MENTION_DEFAULT" envDefault:"channel"`
+	MinSeverity Severity `env:"MENTION_MIN_SEVERITY" envDefault:"critical"`
+}
+
+const (
+	MentionHere    = "here"
+	MentionChannel = "channel"
+	MentionNone    = "none"
+)
+
+// MentionRule mentions here, channel or none along with specific users and
+// groups, given as chat IDs, for what it matches at or above MinSeverity.
+type MentionRule struct {
+	Name        string   `json:"name"`
+	Mention     string   `json:"mention"`
+	Users       []string `json:"users"`
+	Groups      []string `json:"groups"`
+	MinSeverity Severity `json:"min_severity"`
+	Matcher     Matcher  `json:"match"`
+}
+
+// Mention is who an alarm or event notifies, each chat notifier formats it
+// its own way.
+type Mention struct {
+	Broadcast string
+	Users     []string
+	Groups    []string
+}
@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+type DeviceMonitorConfig struct {
+	Enabled  bool          `env:"DEVICE_MONITOR_ENABLED"`
+	Interval time.Duration `env:"DEVICE_MONITOR_INTERVAL" envDefault:"1m"`
+}
+
+// States of a device in stat/device.
+const (
+	DeviceStateDisconnected    int64 = 0
+	DeviceStateConnected       int64 = 1
+	DeviceStatePendingAdoption int64 = 2
+	DeviceStateUpgrading       int64 = 4
+	DeviceStateProvisioning    int64 = 5
+	DeviceStateHeartbeatMissed int64 = 6
+	DeviceStateAdopting        int64 = 7
+	DeviceStateAdoptionError   int64 = 9
+	DeviceStateAdoptionFailed  int64 = 10
+	DeviceStateIsolated        int64 = 11
+)
+
+// DeviceStateNames are used in the notifications of state changes.
+var DeviceStateNames = map[int64]string{
+	DeviceStateDisconnected:    "disconnected",
+	DeviceStateConnected:       "connected",
+	DeviceStatePendingAdoption: "pending adoption",
+	DeviceStateUpgrading:       "upgrading",
+	DeviceStateProvisioning:    "provisioning",
+	DeviceStateHeartbeatMissed: "heartbeat missed",
+	DeviceStateAdopting:        "adopting",
+	DeviceStateAdoptionError:   "adoption error",
+	DeviceStateAdoptionFailed:  "adoption failed",
+	DeviceStateIsolated:        "isolated",
+}
+
+// Keys of the events synthesized from device state changes.
+const (
+	EventKeyDeviceOffline      = "EVT_UN_DeviceOffline"
+	EventKeyDeviceOnline       = "EVT_UN_DeviceOnline"
+	EventKeyDeviceStateChanged = "EVT_UN_DeviceStateChanged"
+)
@@ -0,0 +1,9 @@
+package model
+
+// NameConfig files are optional, ALIASES_FILE is a JSON object of MACs to
+// names and OUI_FILE is the IEEE oui.txt or oui.csv registry used instead of
+// the bundled vendors.
+type NameConfig struct {
+	AliasesFile string `env:"ALIASES_FILE"`
+	OUIFile     string `env:"OUI_FILE"`
+}
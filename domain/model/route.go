@@ -0,0 +1,67 @@
+package model
+
+type RouteConfig struct {
+	File string `env:"ROUTES_FILE"`
+}
+
+const (
+	ReceiverTypeSlack     = "slack"
+	ReceiverTypePagerDuty = "pagerduty"
+	ReceiverTypeFile      = "file"
+
+	ReceiverSlackAlarms = "slack-alarms"
+	ReceiverSlackEvents = "slack-events"
+)
+
+type Routes struct {
+	Receivers []Receiver `json:"receivers"`
+	Route     Route      `json:"route"`
+}
+
+// Receiver is a named destination. Webhook is used by slack receivers,
+// RoutingKey by pagerduty receivers and Path by file receivers.
+type Receiver struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Webhook    string `json:"webhook"`
+	RoutingKey string `json:"routing_key"`
+	Path       string `json:"path"`
+}
+
+// Route sends what it matches to its receiver unless one of its child routes
+// matches. Children are evaluated in order and evaluation stops at the first
+// matching child unless that child has Continue set. A route without a
+// receiver uses its parent's.
+type Route struct {
+	Receiver string  `json:"receiver"`
+	Match    Matcher `json:"match"`
+	Continue bool    `json:"continue"`
+	Routes   []Route `json:"routes"`
+}
+
+type PagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key,omitempty"`
+	Payload     PagerDutyPayload `json:"payload"`
+}
+
+type PagerDutyPayload struct {
+	Summary       string      `json:"summary"`
+	Source        string      `json:"source"`
+	Severity      string      `json:"severity"`
+	Timestamp     string      `json:"timestamp,omitempty"`
+	Component     string      `json:"component,omitempty"`
+	Group         string      `json:"group,omitempty"`
+	Class         string      `json:"class,omitempty"`
+	CustomDetails interface{} `json:"custom_details,omitempty"`
+}
+
+type FileRecord struct {
+	Type       string      `json:"type"`
+	Controller string      `json:"controller,omitempty"`
+	Site       string      `json:"site"`
+	Severity   Severity    `json:"severity,omitempty"`
+	Text       string      `json:"text"`
+	Data       interface{} `json:"data"`
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+)
+
+type ScheduleConfig struct {
+	File            string `env:"SCHEDULES_FILE"`
+	AdminListenAddr string `env:"ADMIN_LISTEN_ADDR"`
+	AdminToken      string `env:"ADMIN_TOKEN"`
+}
+
+// Actions applied to the alarms and events matching an active schedule or
+// maintenance window. Queued alarms and events are sent as a digest once the
+// window ends.
+const (
+	ScheduleActionSuppress  = "suppress"
+	ScheduleActionDowngrade = "downgrade"
+	ScheduleActionQueue     = "queue"
+)
+
+// Schedule is active during any of its windows, for example quiet hours on
+// weeknights.
+type Schedule struct {
+	Name    string       `json:"name"`
+	Action  string       `json:"action"`
+	Windows []TimeWindow `json:"windows"`
+	Matcher Matcher      `json:"match"`
+}
+
+// MaintenanceWindow is an ad hoc window for a controller, site or devices
+// created through the admin API. Empty Controller, Site and Devices match
+// everything.
+type MaintenanceWindow struct {
+	ID         string    `json:"id"`
+	Controller string    `json:"controller,omitempty"`
+	Site       string    `json:"site,omitempty"`
+	Devices    []string  `json:"devices,omitempty"`
+	Action     string    `json:"action"`
+	Comment    string    `json:"comment,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
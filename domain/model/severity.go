@@ -0,0 +1,17 @@
+package model
+
+// Severity is the normalised severity of an alarm or event used by notifiers
+// for colours, mentions and priorities.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// SeverityConfig overrides are key=severity pairs where the key is a glob
+// pattern, e.g. EVT_WU_Roam*=info.
+type SeverityConfig struct {
+	Overrides []string `env:"SEVERITY_OVERRIDES" envSeparator:","`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+)
+
+// State holds the checkpoints of every controller, the maintenance windows
+// created through the admin API and the known clients, keyed to when they last
+// connected. ClientsSince is when clients started to be tracked.
+type State struct {
+	Controllers  map[string]ControllerState `json:"controllers"`
+	Maintenance  []MaintenanceWindow        `json:"maintenance,omitempty"`
+	Clients      map[string]time.Time       `json:"clients,omitempty"`
+	ClientsSince time.Time                  `json:"clients_since"`
+}
+
+type ControllerState map[string]*SiteState
+
+// SiteState holds the controller datetime of the newest alarm and event
+// notified for a site and the IDs notified recently, keyed to their datetime.
+type SiteState struct {
+	AlarmsChecked time.Time            `json:"alarms_checked"`
+	EventsChecked time.Time            `json:"events_checked"`
+	AlarmIDs      map[string]time.Time `json:"alarm_ids"`
+	EventIDs      map[string]time.Time `json:"event_ids"`
+}
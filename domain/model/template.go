@@ -0,0 +1,5 @@
+package model
+
+type TemplateConfig struct {
+	Dir string `env:"TEMPLATES_DIR"`
+}
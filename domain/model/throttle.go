@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// ThrottleConfig rates are notifications per minute, bursts are how many can
+// be sent at once after a quiet period.
+type ThrottleConfig struct {
+	Enabled          bool          `env:"THROTTLE_ENABLED"`
+	ReceiverRate     float64       `env:"THROTTLE_RECEIVER_RATE" envDefault:"30"`
+	ReceiverBurst    int           `env:"THROTTLE_RECEIVER_BURST" envDefault:"60"`
+	KeyRate          float64       `env:"THROTTLE_KEY_RATE" envDefault:"5"`
+	KeyBurst         int           `env:"THROTTLE_KEY_BURST" envDefault:"10"`
+	BreakerThreshold int           `env:"BREAKER_THRESHOLD"`
+	BreakerRecovery  time.Duration `env:"BREAKER_RECOVERY" envDefault:"10m"`
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -19,6 +20,18 @@ type UnifiPagination struct {
 	Start int `json:"_start"`
 }
 
+type UnifiSites struct {
+	Meta  Meta        `json:"meta"`
+	Sites []UnifiSite `json:"data"`
+}
+
+type UnifiSite struct {
+	ID   string `json:"_id"`
+	Name string `json:"name"`
+	Desc string `json:"desc"`
+	Role string `json:"role"`
+}
+
 type UnifiSiteAlarms map[string]UnifiAlarms
 
 type UnifiSiteEvents map[string]UnifiEvents
@@ -29,9 +42,31 @@ type Meta struct {
 	Msg   string `json:"msg"`
 }
 
+type UnifiStreamMeta struct {
+	RC      string `json:"rc"`
+	Message string `json:"message"`
+}
+
+type UnifiStreamMessage struct {
+	Meta UnifiStreamMeta `json:"meta"`
+	Data json.RawMessage `json:"data"`
+}
+
+// UnifiInventory is what the controller knows about the devices and clients of
+// a site, Names, Vendors and FirstSeen are by MAC and Hosts by IP.
+type UnifiInventory struct {
+	Names     map[string]string
+	Vendors   map[string]string
+	Hosts     map[string]string
+	FirstSeen map[string]time.Time
+}
+
 type UnifiAlarms struct {
-	Meta   Meta         `json:"meta"`
-	Alarms []UnifiAlarm `json:"data"`
+	Meta            Meta         `json:"meta"`
+	Alarms          []UnifiAlarm `json:"data"`
+	SiteDescription string       `json:"-"`
+	Controller      string       `json:"-"`
+	UnifiInventory  `json:"-"`
 }
 
 type UnifiAlarm struct {
@@ -80,11 +115,17 @@ type UnifiAlarm struct {
 	VLAN                  int64     `json:"vlan"`
 	ICMPType              int64     `json:"icmp_type"`
 	ICMPCode              int64     `json:"icmp_code"`
+	Severity              Severity  `json:"-"`
+	Mention               Mention   `json:"-"`
+	Remote                GeoIP     `json:"-"`
 }
 
 type UnifiEvents struct {
-	Meta   Meta         `json:"meta"`
-	Events []UnifiEvent `json:"data"`
+	Meta            Meta         `json:"meta"`
+	Events          []UnifiEvent `json:"data"`
+	SiteDescription string       `json:"-"`
+	Controller      string       `json:"-"`
+	UnifiInventory  `json:"-"`
 }
 
 type UnifiEvent struct {
@@ -111,6 +152,8 @@ type UnifiEvent struct {
 	RadioTo               string    `json:"radio_to"`
 	Gw                    string    `json:"gw"`
 	GwName                string    `json:"gw_name"`
+	Sw                    string    `json:"sw"`
+	SwName                string    `json:"sw_name"`
 	ApName                string    `json:"ap_name"`
 	Timestamp             int64     `json:"timestamp"`
 	FlowID                int64     `json:"flow_id"`
@@ -144,6 +187,9 @@ type UnifiEvent struct {
 	ApFrom                string    `json:"ap_from"`
 	ApTo                  string    `json:"ap_to"`
 	Name                  string    `json:"name"`
+	Severity              Severity  `json:"-"`
+	Mention               Mention   `json:"-"`
+	Remote                GeoIP     `json:"-"`
 }
 
 type UnifiSiteUsers map[string]UnifiUsers
@@ -163,22 +209,50 @@ type UnifiUser struct {
 	LastSeen  int64  `json:"last_seen"`
 	IsWired   bool   `json:"is_wired"`
 	Hostname  string `json:"hostname"`
+	Name      string `json:"name"`
+	Note      string `json:"note"`
+	IP        string `json:"ip"`
+	LastIP    string `json:"last_ip"`
 }
 
 type UnifiSiteDevices map[string]UnifiDevices
 
 type UnifiDevices struct {
-	Meta    Meta          `json:"meta"`
-	Devices []UnifiDevice `json:"data"`
+	Meta            Meta          `json:"meta"`
+	Devices         []UnifiDevice `json:"data"`
+	SiteDescription string        `json:"-"`
+	Controller      string        `json:"-"`
 }
 
 type UnifiDevice struct {
-	ID       string `json:"_id"`
-	Mac      string `json:"mac"`
-	State    int64  `json:"state"`
-	Adopted  bool   `json:"adopted"`
-	Disabled bool   `json:"disabled"`
-	Type     string `json:"type"`
-	Model    string `json:"model"`
-	Name     string `json:"name"`
+	ID                string      `json:"_id"`
+	Mac               string      `json:"mac"`
+	State             int64       `json:"state"`
+	Adopted           bool        `json:"adopted"`
+	Disabled          bool        `json:"disabled"`
+	Type              string      `json:"type"`
+	Model             string      `json:"model"`
+	Name              string      `json:"name"`
+	LastSeen          int64       `json:"last_seen"`
+	Version           string      `json:"version"`
+	Upgradable        bool        `json:"upgradable"`
+	UpgradeToFirmware string      `json:"upgrade_to_firmware"`
+	Uplink            UnifiUplink `json:"uplink"`
+	Wan1              UnifiWan    `json:"wan1"`
+	Wan2              UnifiWan    `json:"wan2"`
+}
+
+// UnifiUplink is the active uplink of a device, for a gateway the active WAN.
+type UnifiUplink struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+	Type string `json:"type"`
+	Up   bool   `json:"up"`
+}
+
+type UnifiWan struct {
+	Name   string `json:"name"`
+	IfName string `json:"ifname"`
+	IP     string `json:"ip"`
+	Up     bool   `json:"up"`
 }
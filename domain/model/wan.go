@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+type WANMonitorConfig struct {
+	Enabled  bool          `env:"WAN_MONITOR_ENABLED"`
+	Interval time.Duration `env:"WAN_MONITOR_INTERVAL" envDefault:"1m"`
+}
+
+// Keys of the events synthesized from gateway uplink changes.
+const (
+	EventKeyWANFailover     = "EVT_UN_WANFailover"
+	EventKeyWANFailback     = "EVT_UN_WANFailback"
+	EventKeyPublicIPChanged = "EVT_UN_PublicIPChanged"
+)
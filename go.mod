@@ -4,10 +4,11 @@ go 1.12
 
 require (
 	github.com/caarlos0/env v3.5.0+incompatible
-	github.com/gorilla/websocket v1.4.0 // indirect
+	github.com/gorilla/websocket v1.4.0
 	github.com/lusis/go-slackbot v0.0.0-20180109053408-401027ccfef5 // indirect
 	github.com/lusis/slack-test v0.0.0-20190426140909-c40012f20018 // indirect
 	github.com/nlopes/slack v0.6.0
+	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/sirupsen/logrus v1.8.1
 )
@@ -0,0 +1,133 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+const maintenancePath = "/maintenance"
+
+type AdminHandler struct {
+	Config   model.ScheduleConfig
+	Schedule ScheduleHandler
+	Logger   *logrus.Logger
+	server   *http.Server
+}
+
+// maintenanceRequest is a maintenance window to create, Duration can be given
+// instead of End, e.g. 2h.
+type maintenanceRequest struct {
+	model.MaintenanceWindow
+	Duration string `json:"duration"`
+}
+
+func NewAdminHandler(config model.ScheduleConfig, scheduleHandler ScheduleHandler, logger *logrus.Logger) AdminHandler {
+	h := AdminHandler{Config: config, Schedule: scheduleHandler, Logger: logger}
+	mux := http.NewServeMux()
+	mux.HandleFunc(maintenancePath, h.authorize(h.maintenance))
+	mux.HandleFunc(maintenancePath+"/", h.authorize(h.maintenanceWindow))
+	h.server = &http.Server{Addr: config.AdminListenAddr, Handler: mux, ReadTimeout: time.Second * 30, WriteTimeout: time.Second * 30}
+	return h
+}
+
+// ListenAndServe serves the admin API until Shutdown is called.
+func (h *AdminHandler) ListenAndServe() error {
+	h.Logger.Infof("admin api listening on %s", h.Config.AdminListenAddr)
+	err := h.server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (h *AdminHandler) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	return h.server.Shutdown(ctx)
+}
+
+// authorize requires the admin token as a bearer token, an empty token is
+// never accepted.
+func (h *AdminHandler) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if h.Config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.Config.AdminToken)) != 1 {
+			h.writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// maintenance lists the maintenance windows on GET and creates one on POST.
+func (h *AdminHandler) maintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.writeJSON(w, http.StatusOK, h.Schedule.Maintenance())
+	case http.MethodPost:
+		request := maintenanceRequest{}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("could not parse maintenance window, error=%s", err))
+			return
+		}
+		window := request.MaintenanceWindow
+		if request.Duration != "" {
+			duration, err := time.ParseDuration(request.Duration)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if window.Start.IsZero() {
+				window.Start = time.Now()
+			}
+			window.End = window.Start.Add(duration)
+		}
+		window, err = h.Schedule.AddMaintenance(window)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		h.writeJSON(w, http.StatusCreated, window)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		h.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// maintenanceWindow ends the maintenance window in the path on DELETE.
+func (h *AdminHandler) maintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		h.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, maintenancePath+"/")
+	if !h.Schedule.RemoveMaintenance(id) {
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("maintenance window %q not found", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		h.Logger.Errorf("could not write admin api response, error=%s", err)
+	}
+}
+
+func (h *AdminHandler) writeError(w http.ResponseWriter, status int, err error) {
+	h.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,167 @@
+package infrastructure
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+var macPrefixPattern = regexp.MustCompile(`^[0-9a-f]{2}(?::[0-9a-f]{2}){0,5}$`)
+
+// connectKeys are the controller events of a client joining a network.
+var connectKeys = []string{"EVT_WU_Connected", "EVT_WG_Connected", "EVT_LU_Connected", "EVT_LG_Connected"}
+
+// NewClientHandler reports the clients that join a network for the first
+// time. Clients the controller saw before clients were first tracked are
+// known, as are the clients that connected since, they are saved to the state
+// file so clients joining while the process is down are still reported.
+type NewClientHandler struct {
+	Config model.NewClientConfig
+	Names  NameHandler
+	State  StateHandler
+	Logger *logrus.Logger
+	known  *knownClients
+}
+
+// knownClients maps every known client to when it last connected, since is
+// when clients started to be tracked.
+type knownClients struct {
+	sync.Mutex
+	macs  map[string]time.Time
+	since time.Time
+}
+
+func NewNewClientHandler(config model.NewClientConfig, nameHandler NameHandler, stateHandler StateHandler, logger *logrus.Logger) (NewClientHandler, error) {
+	h := NewClientHandler{Config: config, Names: nameHandler, State: stateHandler, Logger: logger, known: &knownClients{macs: map[string]time.Time{}}}
+	if config.Enabled {
+		h.known.macs, h.known.since = stateHandler.KnownClients()
+	}
+	for i, prefix := range config.Allowlist {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if !macPrefixPattern.MatchString(prefix) {
+			return NewClientHandler{}, fmt.Errorf("NEW_CLIENT_ALLOWLIST: invalid mac prefix %q", prefix)
+		}
+		h.Config.Allowlist[i] = prefix
+	}
+	for _, network := range config.Networks {
+		if _, err := path.Match(network, ""); err != nil {
+			return NewClientHandler{}, fmt.Errorf("NEW_CLIENT_NETWORKS: invalid pattern %q, error=%s", network, err)
+		}
+	}
+	return h, nil
+}
+
+// DetectEvents adds a new client event after the connect event of every client
+// seen for the first time.
+func (h *NewClientHandler) DetectEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	if !h.Config.Enabled {
+		return unifiSiteEvents
+	}
+
+	h.known.Lock()
+	defer h.known.Unlock()
+	now := time.Now()
+	connected := false
+	for site, unifiEvents := range unifiSiteEvents {
+		if unifiEvents.FirstSeen == nil {
+			h.Logger.WithField("site", site).Debug("skipping new client detection until the client list is loaded")
+			continue
+		}
+		detectedEvents := []model.UnifiEvent{}
+		for _, unifiEvent := range unifiEvents.Events {
+			detectedEvents = append(detectedEvents, unifiEvent)
+			mac := strings.ToLower(unifiEvent.User)
+			if mac == "" || !containsString(connectKeys, unifiEvent.Key) {
+				continue
+			}
+			id := fmt.Sprintf("%s/%s/%s", unifiEvents.Controller, site, mac)
+			_, known := h.known.macs[id]
+			h.known.macs[id] = now
+			connected = true
+			if known {
+				continue
+			}
+			if firstSeen, ok := unifiEvents.FirstSeen[mac]; ok && firstSeen.Before(h.known.since) {
+				continue
+			}
+
+			network := firstNonEmpty(unifiEvent.SSID, unifiEvent.Network)
+			if h.allowed(mac) || !h.onNetwork(network) {
+				h.Logger.WithField("site", site).Debugf("not reporting new client %s on %q", mac, network)
+				continue
+			}
+			h.Logger.WithField("site", site).Infof("new client %s on %q", mac, network)
+			detectedEvents = append(detectedEvents, h.newClientEvent(unifiEvents, unifiEvent, mac, network))
+		}
+		unifiEvents.Events = detectedEvents
+		unifiSiteEvents[site] = unifiEvents
+	}
+
+	if connected {
+		pruneIDs(h.known.macs, now, h.Config.KnownTTL, h.Config.KnownMax)
+		macs := make(map[string]time.Time, len(h.known.macs))
+		for id, seen := range h.known.macs {
+			macs[id] = seen
+		}
+		h.State.SaveClients(macs)
+	}
+	return unifiSiteEvents
+}
+
+func (h *NewClientHandler) allowed(mac string) bool {
+	for _, prefix := range h.Config.Allowlist {
+		if strings.HasPrefix(mac, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *NewClientHandler) onNetwork(network string) bool {
+	return len(h.Config.Networks) == 0 || matchGlobs(h.Config.Networks, network)
+}
+
+func (h *NewClientHandler) newClientEvent(unifiEvents model.UnifiEvents, connected model.UnifiEvent, mac string, network string) model.UnifiEvent {
+	client := mac
+	if name := firstNonEmpty(unifiEvents.Names[mac], connected.Hostname); name != "" && !strings.Contains(name, mac) {
+		client = fmt.Sprintf("%s (%s)", name, mac)
+	} else if name != "" {
+		client = name
+	}
+
+	details := []string{}
+	if vendor := h.Names.Vendor(unifiEvents.Vendors, mac); vendor != "" {
+		details = append(details, fmt.Sprintf("vendor %s", vendor))
+	}
+	if network != "" {
+		details = append(details, fmt.Sprintf("network %s", network))
+	}
+	if ap := strings.ToLower(connected.Ap); ap != "" {
+		details = append(details, fmt.Sprintf("AP %s", firstNonEmpty(connected.ApName, unifiEvents.Names[ap], ap)))
+	}
+	if sw := strings.ToLower(connected.Sw); sw != "" {
+		details = append(details, fmt.Sprintf("switch %s", firstNonEmpty(connected.SwName, unifiEvents.Names[sw], sw)))
+	}
+	if connected.IP != "" {
+		details = append(details, fmt.Sprintf("IP %s", connected.IP))
+	}
+
+	msg := fmt.Sprintf("New client %s joined", client)
+	if len(details) > 0 {
+		msg = fmt.Sprintf("%s, %s", msg, strings.Join(details, ", "))
+	}
+	unifiEvent := syntheticEvent(fmt.Sprintf("new-client-%s-%d", mac, connected.Datetime.Unix()), model.EventKeyNewClient, connected.Datetime, msg)
+	unifiEvent.User, unifiEvent.Hostname = connected.User, connected.Hostname
+	unifiEvent.SSID, unifiEvent.Network = connected.SSID, connected.Network
+	unifiEvent.Ap, unifiEvent.ApName = connected.Ap, connected.ApName
+	unifiEvent.Sw, unifiEvent.SwName = connected.Sw, connected.SwName
+	unifiEvent.IP = connected.IP
+	return unifiEvent
+}
@@ -0,0 +1,32 @@
+package infrastructure
+
+import (
+	"sort"
+	"time"
+)
+
+// pruneIDs bounds a set of IDs, each keyed to a datetime such as the
+// controller datetime of a notified alarm or event. IDs expire once they are
+// older than the TTL and the oldest are evicted when there are more than the
+// maximum.
+func pruneIDs(ids map[string]time.Time, now time.Time, ttl time.Duration, maxIDs int) {
+	expired := now.Add(-ttl)
+	for id, datetime := range ids {
+		if datetime.Before(expired) {
+			delete(ids, id)
+		}
+	}
+
+	if maxIDs <= 0 || len(ids) <= maxIDs {
+		return
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return ids[sorted[i]].Before(ids[sorted[j]]) })
+	for _, id := range sorted[:len(sorted)-maxIDs] {
+		delete(ids, id)
+	}
+}
@@ -0,0 +1,259 @@
+package infrastructure
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+const digestTimeFormat = "15:04:05"
+
+type DigestHandler struct {
+	Config  model.DigestConfig
+	Logger  *logrus.Logger
+	digests *digestStore
+}
+
+type digestStore struct {
+	sync.Mutex
+	sites  map[string]*siteDigest
+	forced bool
+}
+
+// siteDigest accumulates the alarms and events of one site, inventory is the
+// one of the newest so the templates can name the summary's devices.
+type siteDigest struct {
+	controller string
+	site       string
+	siteDesc   string
+	inventory  model.UnifiInventory
+	started    time.Time
+	total      int
+	keys       map[string]*keyDigest
+	clients    map[string]int
+	aps        map[string]int
+}
+
+type keyDigest struct {
+	key   string
+	count int
+	first time.Time
+	last  time.Time
+}
+
+type digestCount struct {
+	name  string
+	count int
+}
+
+func NewDigestHandler(config model.DigestConfig, logger *logrus.Logger) DigestHandler {
+	return DigestHandler{Config: config, Logger: logger, digests: &digestStore{sites: map[string]*siteDigest{}}}
+}
+
+// DigestAlarms holds back the alarms that belong in a digest and returns the
+// ones to send immediately.
+func (h *DigestHandler) DigestAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	h.digests.Lock()
+	defer h.digests.Unlock()
+	if !h.Config.Enabled && !h.digests.forced {
+		return unifiSiteAlarms
+	}
+
+	immediateUnifiSiteAlarms := model.UnifiSiteAlarms{}
+	for site, unifiAlarms := range unifiSiteAlarms {
+		immediateUnifiAlarms := unifiAlarms
+		immediateUnifiAlarms.Alarms = nil
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			if h.immediate(unifiAlarm.Key) {
+				immediateUnifiAlarms.Alarms = append(immediateUnifiAlarms.Alarms, unifiAlarm)
+				continue
+			}
+			h.siteDigest(unifiAlarms.Controller, site, unifiAlarms.SiteDescription).addAlarm(unifiAlarm, unifiAlarms.UnifiInventory)
+		}
+		immediateUnifiSiteAlarms[site] = immediateUnifiAlarms
+	}
+	return immediateUnifiSiteAlarms
+}
+
+// DigestEvents holds back the events that belong in a digest and returns the
+// ones to send immediately.
+func (h *DigestHandler) DigestEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	h.digests.Lock()
+	defer h.digests.Unlock()
+	if !h.Config.Enabled && !h.digests.forced {
+		return unifiSiteEvents
+	}
+
+	immediateUnifiSiteEvents := model.UnifiSiteEvents{}
+	for site, unifiEvents := range unifiSiteEvents {
+		immediateUnifiEvents := unifiEvents
+		immediateUnifiEvents.Events = nil
+		for _, unifiEvent := range unifiEvents.Events {
+			if h.immediate(unifiEvent.Key) {
+				immediateUnifiEvents.Events = append(immediateUnifiEvents.Events, unifiEvent)
+				continue
+			}
+			h.siteDigest(unifiEvents.Controller, site, unifiEvents.SiteDescription).addEvent(unifiEvent, unifiEvents.UnifiInventory)
+		}
+		immediateUnifiSiteEvents[site] = immediateUnifiEvents
+	}
+	return immediateUnifiSiteEvents
+}
+
+// Force sends every alarm and event except the immediate keys to the digest,
+// even when digests are disabled, until it is called with false.
+func (h *DigestHandler) Force(forced bool) {
+	h.digests.Lock()
+	defer h.digests.Unlock()
+	h.digests.forced = forced
+}
+
+// Flush returns a summary event for every site whose digest window has ended,
+// or for every site when force is set.
+func (h *DigestHandler) Flush(now time.Time, force bool) []model.UnifiSiteEvents {
+	h.digests.Lock()
+	defer h.digests.Unlock()
+	summaries := []model.UnifiSiteEvents{}
+	for id, digest := range h.digests.sites {
+		if !force && now.Sub(digest.started) < h.Config.Window {
+			continue
+		}
+		h.Logger.WithField("site", digest.site).Infof("sending digest of %d alarms and events", digest.total)
+		summaries = append(summaries, digest.summary(now, h.Config.Top))
+		delete(h.digests.sites, id)
+	}
+	return summaries
+}
+
+func (h *DigestHandler) immediate(key string) bool {
+	if matchGlobs(h.Config.ImmediateKeys, key) {
+		return true
+	}
+	return !h.digests.forced && len(h.Config.Keys) > 0 && !matchGlobs(h.Config.Keys, key)
+}
+
+func (h *DigestHandler) siteDigest(controller string, site string, siteDesc string) *siteDigest {
+	id := fmt.Sprintf("%s/%s", controller, site)
+	digest, ok := h.digests.sites[id]
+	if !ok {
+		digest = newSiteDigest(controller, site, siteDesc, time.Now())
+		h.digests.sites[id] = digest
+	}
+	return digest
+}
+
+func newSiteDigest(controller string, site string, siteDesc string, started time.Time) *siteDigest {
+	return &siteDigest{
+		controller: controller,
+		site:       site,
+		siteDesc:   siteDesc,
+		started:    started,
+		keys:       map[string]*keyDigest{},
+		clients:    map[string]int{},
+		aps:        map[string]int{},
+	}
+}
+
+func (d *siteDigest) addAlarm(unifiAlarm model.UnifiAlarm, inventory model.UnifiInventory) {
+	d.inventory = inventory
+	d.add(unifiAlarm.Key, unifiAlarm.Datetime, unifiAlarm.SrcIP, firstNonEmpty(unifiAlarm.ApName, unifiAlarm.Ap))
+}
+
+func (d *siteDigest) addEvent(unifiEvent model.UnifiEvent, inventory model.UnifiInventory) {
+	d.inventory = inventory
+	d.add(unifiEvent.Key, unifiEvent.Datetime, firstNonEmpty(unifiEvent.Hostname, unifiEvent.User), firstNonEmpty(unifiEvent.ApName, unifiEvent.Ap))
+}
+
+func (d *siteDigest) add(key string, datetime time.Time, client string, ap string) {
+	if key == "" {
+		key = "unknown"
+	}
+	d.total++
+	k, ok := d.keys[key]
+	if !ok {
+		k = &keyDigest{key: key, first: datetime, last: datetime}
+		d.keys[key] = k
+	}
+	k.count++
+	if datetime.Before(k.first) {
+		k.first = datetime
+	}
+	if datetime.After(k.last) {
+		k.last = datetime
+	}
+	if client != "" {
+		d.clients[client]++
+	}
+	if ap != "" {
+		d.aps[ap]++
+	}
+}
+
+func (d *siteDigest) summary(now time.Time, top int) model.UnifiSiteEvents {
+	keys := []*keyDigest{}
+	for _, k := range d.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].count != keys[j].count {
+			return keys[i].count > keys[j].count
+		}
+		return keys[i].key < keys[j].key
+	})
+
+	lines := []string{fmt.Sprintf("Digest of %d alarms and events from %s to %s", d.total, d.started.Format(digestTimeFormat), now.Format(digestTimeFormat))}
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s %d, first %s, last %s", k.key, k.count, k.first.Local().Format(digestTimeFormat), k.last.Local().Format(digestTimeFormat)))
+	}
+	if clients := topCounts(d.clients, top); clients != "" {
+		lines = append(lines, fmt.Sprintf("Top clients: %s", clients))
+	}
+	if aps := topCounts(d.aps, top); aps != "" {
+		lines = append(lines, fmt.Sprintf("Top APs: %s", aps))
+	}
+
+	unifiEvent := syntheticEvent(fmt.Sprintf("digest-%s-%s-%d", d.controller, d.site, now.Unix()), model.EventKeyDigest, now, strings.Join(lines, "\n"))
+	return model.UnifiSiteEvents{d.site: model.UnifiEvents{
+		Events:          []model.UnifiEvent{unifiEvent},
+		SiteDescription: d.siteDesc,
+		Controller:      d.controller,
+		UnifiInventory:  d.inventory,
+	}}
+}
+
+func topCounts(counts map[string]int, top int) string {
+	sorted := []digestCount{}
+	for name, count := range counts {
+		sorted = append(sorted, digestCount{name: name, count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	if len(sorted) > top {
+		sorted = sorted[:top]
+	}
+
+	names := []string{}
+	for _, c := range sorted {
+		names = append(names, fmt.Sprintf("%s (%d)", c.name, c.count))
+	}
+	return strings.Join(names, ", ")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
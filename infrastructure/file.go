@@ -0,0 +1,66 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// FileHandler appends alarms and events to a file as JSON lines.
+type FileHandler struct {
+	Config    model.Receiver
+	Templates TemplateHandler
+	Logger    *logrus.Logger
+	lock      *sync.Mutex
+}
+
+func NewFileHandler(config model.Receiver, templateHandler TemplateHandler, logger *logrus.Logger) FileHandler {
+	return FileHandler{Config: config, Templates: templateHandler, Logger: logger, lock: &sync.Mutex{}}
+}
+
+func (h *FileHandler) NotifyAlarms(unifiSiteAlarms model.UnifiSiteAlarms) error {
+	records := []model.FileRecord{}
+	for site, unifiAlarms := range unifiSiteAlarms {
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			records = append(records, model.FileRecord{Type: SubjectTypeAlarm, Controller: unifiAlarms.Controller, Site: site, Severity: unifiAlarm.Severity, Text: h.Templates.AlarmText(model.ReceiverTypeFile, site, unifiAlarms, unifiAlarm, ""), Data: unifiAlarm})
+		}
+	}
+	return h.write(records)
+}
+
+func (h *FileHandler) NotifyEvents(unifiSiteEvents model.UnifiSiteEvents) error {
+	records := []model.FileRecord{}
+	for site, unifiEvents := range unifiSiteEvents {
+		for _, unifiEvent := range unifiEvents.Events {
+			records = append(records, model.FileRecord{Type: SubjectTypeEvent, Controller: unifiEvents.Controller, Site: site, Severity: unifiEvent.Severity, Text: h.Templates.EventText(model.ReceiverTypeFile, site, unifiEvents, unifiEvent, ""), Data: unifiEvent})
+		}
+	}
+	return h.write(records)
+}
+
+func (h *FileHandler) write(records []model.FileRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	f, err := os.OpenFile(h.Config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	h.Logger.Debugf("wrote %d records to %s", len(records), h.Config.Path)
+	return f.Close()
+}
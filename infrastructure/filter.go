@@ -0,0 +1,98 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+type FilterHandler struct {
+	Config model.FilterConfig
+	Logger *logrus.Logger
+	rules  []filterRule
+}
+
+type filterRule struct {
+	model.FilterRule
+	matcher matcher
+}
+
+func NewFilterHandler(config model.FilterConfig, logger *logrus.Logger) (FilterHandler, error) {
+	h := FilterHandler{Config: config, Logger: logger}
+	if config.RulesFile == "" {
+		return h, nil
+	}
+
+	rulesBytes, err := ioutil.ReadFile(config.RulesFile)
+	if err != nil {
+		return FilterHandler{}, err
+	}
+
+	rules := []model.FilterRule{}
+	err = json.Unmarshal(rulesBytes, &rules)
+	if err != nil {
+		return FilterHandler{}, fmt.Errorf("could not parse filter rules file %s, error=%s", config.RulesFile, err)
+	}
+
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Action != model.FilterActionInclude && rule.Action != model.FilterActionExclude {
+			return FilterHandler{}, fmt.Errorf("filter %s: invalid action %q, must be %s or %s", rule.Name, rule.Action, model.FilterActionInclude, model.FilterActionExclude)
+		}
+		m, err := newMatcher(rule.Matcher)
+		if err != nil {
+			return FilterHandler{}, fmt.Errorf("filter %s: %s", rule.Name, err)
+		}
+		h.rules = append(h.rules, filterRule{FilterRule: rule, matcher: m})
+	}
+	logger.Infof("loaded %d filter rules from %s", len(h.rules), config.RulesFile)
+	return h, nil
+}
+
+func (h *FilterHandler) FilterAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	filteredUnifiSiteAlarms := model.UnifiSiteAlarms{}
+	for site, unifiAlarms := range unifiSiteAlarms {
+		filteredUnifiAlarms := unifiAlarms
+		filteredUnifiAlarms.Alarms = nil
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			if h.include(alarmSubject(unifiAlarms.Controller, site, unifiAlarms.SiteDescription, unifiAlarm), unifiAlarm.ID) {
+				filteredUnifiAlarms.Alarms = append(filteredUnifiAlarms.Alarms, unifiAlarm)
+			}
+		}
+		filteredUnifiSiteAlarms[site] = filteredUnifiAlarms
+	}
+	return filteredUnifiSiteAlarms
+}
+
+func (h *FilterHandler) FilterEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	filteredUnifiSiteEvents := model.UnifiSiteEvents{}
+	for site, unifiEvents := range unifiSiteEvents {
+		filteredUnifiEvents := unifiEvents
+		filteredUnifiEvents.Events = nil
+		for _, unifiEvent := range unifiEvents.Events {
+			if h.include(eventSubject(unifiEvents.Controller, site, unifiEvents.SiteDescription, unifiEvent), unifiEvent.ID) {
+				filteredUnifiEvents.Events = append(filteredUnifiEvents.Events, unifiEvent)
+			}
+		}
+		filteredUnifiSiteEvents[site] = filteredUnifiEvents
+	}
+	return filteredUnifiSiteEvents
+}
+
+// include evaluates the rules in order, the first matching rule decides and
+// anything no rule matches is included.
+func (h *FilterHandler) include(s subject, id string) bool {
+	for _, rule := range h.rules {
+		if rule.matcher.match(s) {
+			h.Logger.WithFields(logrus.Fields{"site": s.Site, "type": s.Type}).Debugf("filter %s matched %s %s, action=%s", rule.Name, s.Key, id, rule.Action)
+			return rule.Action == model.FilterActionInclude
+		}
+	}
+	return true
+}
@@ -0,0 +1,253 @@
+package infrastructure
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// FirmwareHandler follows the firmware of every device, reporting upgrades as
+// they start, complete or fail, and sends a weekly summary of the devices and
+// controllers with an update available.
+type FirmwareHandler struct {
+	Config   model.FirmwareConfig
+	Logger   *logrus.Logger
+	summary  *timeWindow
+	firmware *firmwareStore
+}
+
+type firmwareStore struct {
+	sync.Mutex
+	devices     map[string]*deviceFirmware
+	sites       map[string]*siteFirmware
+	controllers map[string]model.UnifiSysinfoData
+	nextSummary time.Time
+}
+
+// deviceFirmware is the version and state of a device at the previous poll,
+// from, to and started are set while it is upgrading.
+type deviceFirmware struct {
+	version   string
+	state     int64
+	upgrading bool
+	from      string
+	to        string
+	started   time.Time
+	seen      bool
+}
+
+// siteFirmware holds the devices of a site with an update available at the
+// previous poll.
+type siteFirmware struct {
+	controller string
+	site       string
+	siteDesc   string
+	outdated   []string
+}
+
+func NewFirmwareHandler(config model.FirmwareConfig, logger *logrus.Logger) (FirmwareHandler, error) {
+	h := FirmwareHandler{Config: config, Logger: logger, firmware: &firmwareStore{
+		devices:     map[string]*deviceFirmware{},
+		sites:       map[string]*siteFirmware{},
+		controllers: map[string]model.UnifiSysinfoData{},
+	}}
+	if config.SummaryDay == "" {
+		return h, nil
+	}
+	summary, err := newTimeWindow(model.TimeWindow{Days: []string{config.SummaryDay}, Start: config.SummaryTime, End: config.SummaryTime})
+	if err != nil {
+		return FirmwareHandler{}, fmt.Errorf("firmware summary: %s", err)
+	}
+	h.summary = &summary
+	h.firmware.nextSummary = h.next(time.Now())
+	return h, nil
+}
+
+// CompareFirmware returns an event for every upgrade that started, completed
+// or failed since the previous poll and for a controller update becoming
+// available. The sites and devices of the controller that are no longer in the
+// poll are forgotten.
+func (h *FirmwareHandler) CompareFirmware(controller string, unifiSiteDevices model.UnifiSiteDevices, unifiSysinfo model.UnifiSysinfoData, now time.Time) []model.UnifiSiteEvents {
+	h.firmware.Lock()
+	defer h.firmware.Unlock()
+	for _, device := range h.firmware.devices {
+		device.seen = false
+	}
+
+	siteEvents := []model.UnifiSiteEvents{}
+	for site, unifiDevices := range unifiSiteDevices {
+		unifiEvents := model.UnifiEvents{SiteDescription: unifiDevices.SiteDescription, Controller: unifiDevices.Controller}
+		outdated := []string{}
+		for _, unifiDevice := range unifiDevices.Devices {
+			mac := strings.ToLower(unifiDevice.Mac)
+			if unifiDevice.Upgradable {
+				outdated = append(outdated, fmt.Sprintf("%s %s → %s", deviceLabel(unifiDevice, mac), unifiDevice.Version, firstNonEmpty(unifiDevice.UpgradeToFirmware, "latest")))
+			}
+
+			id := fmt.Sprintf("%s/%s/%s", unifiDevices.Controller, site, mac)
+			previous, ok := h.firmware.devices[id]
+			if !ok {
+				h.firmware.devices[id] = &deviceFirmware{
+					version:   unifiDevice.Version,
+					state:     unifiDevice.State,
+					upgrading: unifiDevice.State == model.DeviceStateUpgrading,
+					from:      unifiDevice.Version,
+					to:        unifiDevice.UpgradeToFirmware,
+					started:   now,
+					seen:      true,
+				}
+				continue
+			}
+			previous.seen = true
+			if unifiEvent, ok := h.upgradeEvent(site, unifiDevice, mac, previous, now); ok {
+				unifiEvents.Events = append(unifiEvents.Events, unifiEvent)
+			}
+			if unifiDevice.Version != "" {
+				previous.version = unifiDevice.Version
+			}
+			previous.state = unifiDevice.State
+		}
+		sort.Strings(outdated)
+		h.firmware.sites[fmt.Sprintf("%s/%s", unifiDevices.Controller, site)] = &siteFirmware{controller: unifiDevices.Controller, site: site, siteDesc: unifiDevices.SiteDescription, outdated: outdated}
+		if len(unifiEvents.Events) > 0 {
+			siteEvents = append(siteEvents, model.UnifiSiteEvents{site: unifiEvents})
+		}
+	}
+
+	// forget the sites and devices removed from the controller
+	for id, device := range h.firmware.devices {
+		if !device.seen && strings.HasPrefix(id, controller+"/") {
+			delete(h.firmware.devices, id)
+		}
+	}
+	for id, site := range h.firmware.sites {
+		if _, ok := unifiSiteDevices[site.site]; !ok && site.controller == controller {
+			delete(h.firmware.sites, id)
+		}
+	}
+
+	// the sysinfo is empty when it could not be fetched
+	if unifiSysinfo.Version == "" {
+		return siteEvents
+	}
+	previous, known := h.firmware.controllers[controller]
+	h.firmware.controllers[controller] = unifiSysinfo
+	if known && unifiSysinfo.UpdateAvailable && !previous.UpdateAvailable {
+		h.Logger.WithField("controller", controller).Info("controller update available")
+		unifiEvent := syntheticEvent(fmt.Sprintf("controller-update-%s-%d", controller, now.Unix()), model.EventKeyControllerUpdate, now, controllerUpdate(controller, unifiSysinfo))
+		siteEvents = append(siteEvents, model.UnifiSiteEvents{"": model.UnifiEvents{Events: []model.UnifiEvent{unifiEvent}, Controller: controller}})
+	}
+	return siteEvents
+}
+
+// upgradeEvent tracks a device through an upgrade. An upgrade completes when
+// the device is connected again with a new version and fails when it comes
+// back with the old version or does not come back within the timeout. A new
+// version without the upgrade being seen, e.g. between two polls, is reported
+// as completed.
+func (h *FirmwareHandler) upgradeEvent(site string, unifiDevice model.UnifiDevice, mac string, previous *deviceFirmware, now time.Time) (model.UnifiEvent, bool) {
+	device := deviceLabel(unifiDevice, mac)
+	id := fmt.Sprintf("firmware-%s-%d", mac, now.Unix())
+	var unifiEvent model.UnifiEvent
+	switch {
+	case !previous.upgrading && unifiDevice.State == model.DeviceStateUpgrading:
+		previous.upgrading, previous.from, previous.to, previous.started = true, previous.version, unifiDevice.UpgradeToFirmware, now
+		h.Logger.WithField("site", site).Infof("device %s is upgrading from %s", mac, previous.from)
+		unifiEvent = syntheticEvent(id, model.EventKeyUpgradeStarted, now,
+			fmt.Sprintf("Upgrade of device %s from %s to %s started", device, previous.from, firstNonEmpty(previous.to, "the latest firmware")))
+	case previous.upgrading && unifiDevice.State == model.DeviceStateConnected && unifiDevice.Version != previous.from:
+		previous.upgrading = false
+		unifiEvent = syntheticEvent(id, model.EventKeyUpgradeCompleted, now,
+			fmt.Sprintf("Device %s upgraded from %s to %s in %s", device, previous.from, unifiDevice.Version, humanDuration(now.Sub(previous.started))))
+		unifiEvent.Duration = int64(now.Sub(previous.started) / time.Second)
+	case previous.upgrading && unifiDevice.State == model.DeviceStateConnected:
+		previous.upgrading = false
+		unifiEvent = syntheticEvent(id, model.EventKeyUpgradeFailed, now,
+			fmt.Sprintf("Upgrade of device %s to %s failed, it is still on %s", device, firstNonEmpty(previous.to, "the latest firmware"), unifiDevice.Version))
+	case previous.upgrading && now.Sub(previous.started) > h.Config.Timeout:
+		previous.upgrading = false
+		unifiEvent = syntheticEvent(id, model.EventKeyUpgradeFailed, now,
+			fmt.Sprintf("Upgrade of device %s to %s did not finish within %s, it is %s", device, firstNonEmpty(previous.to, "the latest firmware"), h.Config.Timeout, deviceStateName(unifiDevice.State)))
+	case !previous.upgrading && unifiDevice.State == model.DeviceStateConnected && previous.version != "" && unifiDevice.Version != "" && unifiDevice.Version != previous.version:
+		unifiEvent = syntheticEvent(id, model.EventKeyUpgradeCompleted, now,
+			fmt.Sprintf("Device %s upgraded from %s to %s", device, previous.version, unifiDevice.Version))
+	default:
+		return model.UnifiEvent{}, false
+	}
+
+	switch {
+	case unifiDevice.Type == "uap":
+		unifiEvent.Ap, unifiEvent.ApName = mac, unifiDevice.Name
+	case unifiDevice.Type == "usw":
+		unifiEvent.Sw, unifiEvent.SwName = mac, unifiDevice.Name
+	case containsString(gatewayTypes, unifiDevice.Type):
+		unifiEvent.Gw, unifiEvent.GwName = mac, unifiDevice.Name
+	}
+	return unifiEvent, true
+}
+
+// Summary returns the summaries of the sites with outdated devices and of the
+// controllers with an update available once the summary time has passed.
+func (h *FirmwareHandler) Summary(now time.Time) []model.UnifiSiteEvents {
+	if h.summary == nil {
+		return nil
+	}
+
+	h.firmware.Lock()
+	defer h.firmware.Unlock()
+	if now.Before(h.firmware.nextSummary) {
+		return nil
+	}
+	h.firmware.nextSummary = h.next(now)
+
+	siteEvents := []model.UnifiSiteEvents{}
+	for _, site := range h.firmware.sites {
+		if len(site.outdated) == 0 {
+			continue
+		}
+		devices := "devices"
+		if len(site.outdated) == 1 {
+			devices = "device"
+		}
+		lines := append([]string{fmt.Sprintf("Firmware updates available for %d %s", len(site.outdated), devices)}, site.outdated...)
+		unifiEvent := syntheticEvent(fmt.Sprintf("firmware-summary-%s-%s-%d", site.controller, site.site, now.Unix()), model.EventKeyFirmwareSummary, now, strings.Join(lines, "\n"))
+		siteEvents = append(siteEvents, model.UnifiSiteEvents{site.site: model.UnifiEvents{Events: []model.UnifiEvent{unifiEvent}, SiteDescription: site.siteDesc, Controller: site.controller}})
+	}
+	for controller, unifiSysinfo := range h.firmware.controllers {
+		if !unifiSysinfo.UpdateAvailable {
+			continue
+		}
+		unifiEvent := syntheticEvent(fmt.Sprintf("firmware-summary-%s-%d", controller, now.Unix()), model.EventKeyFirmwareSummary, now, controllerUpdate(controller, unifiSysinfo))
+		siteEvents = append(siteEvents, model.UnifiSiteEvents{"": model.UnifiEvents{Events: []model.UnifiEvent{unifiEvent}, Controller: controller}})
+	}
+	return siteEvents
+}
+
+// next returns the first summary time after a time.
+func (h *FirmwareHandler) next(after time.Time) time.Time {
+	after = after.In(h.summary.location)
+	next := time.Date(after.Year(), after.Month(), after.Day(), h.summary.start/60, h.summary.start%60, 0, 0, after.Location())
+	for !next.After(after) || !h.summary.onDay(next.Weekday()) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func deviceLabel(unifiDevice model.UnifiDevice, mac string) string {
+	name := firstNonEmpty(unifiDevice.Name, mac)
+	if unifiDevice.Model != "" {
+		return fmt.Sprintf("%s (%s)", name, unifiDevice.Model)
+	}
+	return name
+}
+
+func controllerUpdate(controller string, unifiSysinfo model.UnifiSysinfoData) string {
+	name := firstNonEmpty(controller, unifiSysinfo.Hostname, "controller")
+	return fmt.Sprintf("Controller %s has an update available, it is running %s", name, unifiSysinfo.Version)
+}
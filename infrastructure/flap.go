@@ -0,0 +1,238 @@
+package infrastructure
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// reconnectKeys maps the lost contact event of each device type, and the one
+// synthesized by the device monitor, to the event sent when the device comes
+// back.
+var reconnectKeys = map[string]string{
+	model.EventKeyAPLostContact: model.EventKeyAPConnected,
+	model.EventKeySWLostContact: model.EventKeySWConnected,
+	model.EventKeyGWLostContact: model.EventKeyGWConnected,
+	model.EventKeyDeviceOffline: model.EventKeyDeviceOnline,
+}
+
+type FlapHandler struct {
+	Config model.FlapConfig
+	Logger *logrus.Logger
+	flaps  *flapStore
+}
+
+type flapStore struct {
+	sync.Mutex
+	devices map[string]*deviceFlaps
+}
+
+// deviceFlaps tracks one device, pending is the lost contact event held back
+// during the grace period and inventory the one it came with. down and
+// reconnected record which source, the controller or the device monitor, last
+// reported the device going down or coming back, so the report of the other
+// source can be dropped.
+type deviceFlaps struct {
+	controller           string
+	site                 string
+	siteDesc             string
+	name                 string
+	inventory            model.UnifiInventory
+	pending              *model.UnifiEvent
+	released             time.Time
+	flaps                []time.Time
+	flapping             bool
+	down                 bool
+	downByMonitor        bool
+	reconnected          time.Time
+	reconnectedByMonitor bool
+}
+
+func NewFlapHandler(config model.FlapConfig, logger *logrus.Logger) FlapHandler {
+	return FlapHandler{Config: config, Logger: logger, flaps: &flapStore{devices: map[string]*deviceFlaps{}}}
+}
+
+// HoldEvents holds back lost contact events for the grace period. When the
+// device reconnects in time both events are dropped and counted as a flap,
+// once a device flaps the threshold number of times within the window a
+// single flapping event is returned instead. The events are returned newest
+// first, as the controller returns them.
+//
+// The controller and the device monitor both report an outage, a lost contact
+// or reconnect is dropped when the other source already reported it for the
+// same device. Two reports of the same source are never dropped.
+func (h *FlapHandler) HoldEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	if !h.Config.Enabled {
+		return unifiSiteEvents
+	}
+
+	h.flaps.Lock()
+	defer h.flaps.Unlock()
+	now := time.Now()
+	heldUnifiSiteEvents := model.UnifiSiteEvents{}
+	for site, unifiEvents := range unifiSiteEvents {
+		heldUnifiEvents := unifiEvents
+		heldUnifiEvents.Events = nil
+		// oldest first so a lost contact is held before its reconnect is seen
+		sortedEvents := append([]model.UnifiEvent{}, unifiEvents.Events...)
+		sort.SliceStable(sortedEvents, func(i, j int) bool { return sortedEvents[i].Datetime.Before(sortedEvents[j].Datetime) })
+		for _, unifiEvent := range sortedEvents {
+			_, lostContact := reconnectKeys[unifiEvent.Key]
+			mac, name := eventDevice(unifiEvent)
+			if mac == "" || (!lostContact && !isReconnect(unifiEvent.Key)) {
+				heldUnifiEvents.Events = append(heldUnifiEvents.Events, unifiEvent)
+				continue
+			}
+			device := h.device(unifiEvents.Controller, site, unifiEvents.SiteDescription, mac)
+			if name != "" {
+				device.name = name
+			}
+
+			byMonitor := isMonitorEvent(unifiEvent.Key)
+			if lostContact {
+				if device.down && device.downByMonitor != byMonitor {
+					h.Logger.WithField("site", site).Debugf("%s was already reported down, dropping event %s", mac, unifiEvent.ID)
+					continue
+				}
+				device.down, device.downByMonitor = true, byMonitor
+				h.Logger.WithField("site", site).Debugf("holding lost contact event %s of %s for %s", unifiEvent.ID, mac, h.Config.Grace)
+				held := unifiEvent
+				device.pending = &held
+				device.inventory = unifiEvents.UnifiInventory
+				device.released = now.Add(h.Config.Grace)
+				continue
+			}
+
+			if !device.down && device.reconnectedByMonitor != byMonitor && !device.reconnected.IsZero() && now.Sub(device.reconnected) <= h.Config.Window {
+				h.Logger.WithField("site", site).Debugf("%s was already reported reconnected, dropping event %s", mac, unifiEvent.ID)
+				continue
+			}
+			device.down = false
+			device.reconnected, device.reconnectedByMonitor = now, byMonitor
+			if device.pending == nil {
+				heldUnifiEvents.Events = append(heldUnifiEvents.Events, unifiEvent)
+				continue
+			}
+
+			h.Logger.WithField("site", site).Debugf("%s reconnected within the grace period, dropping events %s and %s", mac, device.pending.ID, unifiEvent.ID)
+			device.pending = nil
+			device.flaps = append(recentFlaps(device.flaps, now, h.Config.Window), unifiEvent.Datetime)
+			if !device.flapping && len(device.flaps) >= h.Config.Threshold {
+				device.flapping = true
+				h.Logger.WithField("site", site).Warnf("%s is flapping", mac)
+				heldUnifiEvents.Events = append(heldUnifiEvents.Events, h.flappingEvent(unifiEvent, mac, device.name, device.flaps, now))
+			}
+		}
+		for i, j := 0, len(heldUnifiEvents.Events)-1; i < j; i, j = i+1, j-1 {
+			heldUnifiEvents.Events[i], heldUnifiEvents.Events[j] = heldUnifiEvents.Events[j], heldUnifiEvents.Events[i]
+		}
+		heldUnifiSiteEvents[site] = heldUnifiEvents
+	}
+	return heldUnifiSiteEvents
+}
+
+// Flush releases the lost contact events whose grace period has ended, or
+// every held event when force is set.
+func (h *FlapHandler) Flush(now time.Time, force bool) []model.UnifiSiteEvents {
+	h.flaps.Lock()
+	defer h.flaps.Unlock()
+	released := []model.UnifiSiteEvents{}
+	for id, device := range h.flaps.devices {
+		if device.pending != nil && (force || !now.Before(device.released)) {
+			h.Logger.WithField("site", device.site).Debugf("releasing lost contact event %s", device.pending.ID)
+			released = append(released, model.UnifiSiteEvents{device.site: model.UnifiEvents{
+				Events:          []model.UnifiEvent{*device.pending},
+				SiteDescription: device.siteDesc,
+				Controller:      device.controller,
+				UnifiInventory:  device.inventory,
+			}})
+			device.pending = nil
+		}
+
+		device.flaps = recentFlaps(device.flaps, now, h.Config.Window)
+		if len(device.flaps) == 0 {
+			device.flapping = false
+		}
+		// keep a device that is down or just reconnected to drop the report of
+		// the other source
+		if device.pending == nil && len(device.flaps) == 0 && !device.down && now.Sub(device.reconnected) > h.Config.Window {
+			delete(h.flaps.devices, id)
+		}
+	}
+	return released
+}
+
+func (h *FlapHandler) device(controller string, site string, siteDesc string, mac string) *deviceFlaps {
+	id := fmt.Sprintf("%s/%s/%s", controller, site, mac)
+	device, ok := h.flaps.devices[id]
+	if !ok {
+		device = &deviceFlaps{controller: controller, site: site, siteDesc: siteDesc}
+		h.flaps.devices[id] = device
+	}
+	return device
+}
+
+func (h *FlapHandler) flappingEvent(reconnected model.UnifiEvent, mac string, name string, flaps []time.Time, now time.Time) model.UnifiEvent {
+	minutes := int(math.Ceil(now.Sub(flaps[0]).Minutes()))
+	if minutes < 1 {
+		minutes = 1
+	}
+	device := mac
+	if name != "" {
+		device = fmt.Sprintf("%s (%s)", name, mac)
+	}
+	unifiEvent := syntheticEvent(fmt.Sprintf("flapping-%s-%d", mac, now.Unix()), model.EventKeyDeviceFlapping, now,
+		fmt.Sprintf("Device %s is flapping (%d times in %d minutes)", device, len(flaps), minutes))
+	unifiEvent.Ap, unifiEvent.ApName = reconnected.Ap, reconnected.ApName
+	unifiEvent.Sw, unifiEvent.SwName = reconnected.Sw, reconnected.SwName
+	unifiEvent.Gw, unifiEvent.GwName = reconnected.Gw, reconnected.GwName
+	return unifiEvent
+}
+
+// eventDevice returns the MAC and name of the device a lost contact or
+// connected event is about.
+func eventDevice(unifiEvent model.UnifiEvent) (string, string) {
+	switch {
+	case isMonitorEvent(unifiEvent.Key):
+		return strings.ToLower(firstNonEmpty(unifiEvent.Ap, unifiEvent.Sw, unifiEvent.Gw)), firstNonEmpty(unifiEvent.ApName, unifiEvent.SwName, unifiEvent.GwName)
+	case strings.HasPrefix(unifiEvent.Key, "EVT_AP_"):
+		return strings.ToLower(unifiEvent.Ap), unifiEvent.ApName
+	case strings.HasPrefix(unifiEvent.Key, "EVT_SW_"):
+		return strings.ToLower(unifiEvent.Sw), unifiEvent.SwName
+	case strings.HasPrefix(unifiEvent.Key, "EVT_GW_"):
+		return strings.ToLower(unifiEvent.Gw), unifiEvent.GwName
+	}
+	return "", ""
+}
+
+// isMonitorEvent tells whether an event was synthesized by the device monitor
+// rather than sent by the controller.
+func isMonitorEvent(key string) bool {
+	return key == model.EventKeyDeviceOffline || key == model.EventKeyDeviceOnline
+}
+
+func isReconnect(key string) bool {
+	for _, reconnectKey := range reconnectKeys {
+		if key == reconnectKey {
+			return true
+		}
+	}
+	return false
+}
+
+func recentFlaps(flaps []time.Time, now time.Time, window time.Duration) []time.Time {
+	recent := []time.Time{}
+	for _, flap := range flaps {
+		if now.Sub(flap) < window {
+			recent = append(recent, flap)
+		}
+	}
+	return recent
+}
@@ -0,0 +1,108 @@
+//go:build ignore
+// +build ignore
+
+// gen_oui.go generates oui_generated.go from the IEEE MA-L registry, run it
+// with go generate in the infrastructure directory. -src takes a URL or the
+// path of a downloaded oui.csv.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	src := flag.String("src", "https://standards-oui.ieee.org/oui/oui.csv", "URL or path of the IEEE MA-L registry in csv")
+	out := flag.String("o", "oui_generated.go", "file to write")
+	flag.Parse()
+
+	registry, err := open(*src)
+	if err != nil {
+		log.Fatalf("could not read %s, error=%s", *src, err)
+	}
+	defer registry.Close()
+
+	ouis, err := parse(registry)
+	if err != nil {
+		log.Fatalf("could not parse %s, error=%s", *src, err)
+	}
+	if len(ouis) == 0 {
+		log.Fatalf("no ouis in %s", *src)
+	}
+
+	keys := []string{}
+	for oui := range ouis {
+		keys = append(keys, oui)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen_oui.go from %s; DO NOT EDIT.\n\n", *src)
+	fmt.Fprintf(&buf, "package infrastructure\n\n")
+	fmt.Fprintf(&buf, "// generatedOUIs maps the upper case hex OUIs of the IEEE MA-L registry to\n// the organization they are assigned to.\n")
+	fmt.Fprintf(&buf, "var generatedOUIs = map[string]string{\n")
+	for _, oui := range keys {
+		fmt.Fprintf(&buf, "\t%q: %q,\n", oui, ouis[oui])
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("could not format %s, error=%s", *out, err)
+	}
+	err = ioutil.WriteFile(*out, source, 0644)
+	if err != nil {
+		log.Fatalf("could not write %s, error=%s", *out, err)
+	}
+	log.Printf("wrote %d ouis to %s", len(keys), *out)
+}
+
+func open(src string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.Open(src)
+	}
+	resp, err := http.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// parse reads the Registry,Assignment,Organization Name,Organization Address
+// records of oui.csv.
+func parse(registry io.Reader) (map[string]string, error) {
+	reader := csv.NewReader(registry)
+	reader.FieldsPerRecord = -1
+	ouis := map[string]string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return ouis, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 || record[0] != "MA-L" {
+			continue
+		}
+		oui := strings.ToUpper(strings.TrimSpace(record[1]))
+		if len(oui) != 6 {
+			continue
+		}
+		ouis[oui] = strings.TrimSpace(record[2])
+	}
+}
@@ -0,0 +1,144 @@
+package infrastructure
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// privateNetworks are never looked up, the other address of an alarm or event
+// is the remote one.
+var privateNetworks = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+type GeoIPHandler struct {
+	Config model.GeoIPConfig
+	Logger *logrus.Logger
+	city   *maxminddb.Reader
+	asn    *maxminddb.Reader
+}
+
+func NewGeoIPHandler(config model.GeoIPConfig, logger *logrus.Logger) (GeoIPHandler, error) {
+	h := GeoIPHandler{Config: config, Logger: logger}
+	var err error
+	if config.CityDB != "" {
+		h.city, err = maxminddb.Open(config.CityDB)
+		if err != nil {
+			return GeoIPHandler{}, err
+		}
+		logger.Infof("loaded geoip city database %s", config.CityDB)
+	}
+	if config.ASNDB != "" {
+		h.asn, err = maxminddb.Open(config.ASNDB)
+		if err != nil {
+			return GeoIPHandler{}, err
+		}
+		logger.Infof("loaded geoip asn database %s", config.ASNDB)
+	}
+	return h, nil
+}
+
+// EnrichAlarms looks up the remote address of every alarm with a source or
+// destination IP.
+func (h *GeoIPHandler) EnrichAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	if h.city == nil && h.asn == nil {
+		return unifiSiteAlarms
+	}
+	for site, unifiAlarms := range unifiSiteAlarms {
+		for i, unifiAlarm := range unifiAlarms.Alarms {
+			unifiAlarms.Alarms[i].Remote = h.lookup(site, remoteIP(unifiAlarm.SrcIP, unifiAlarm.DestIP))
+		}
+		unifiSiteAlarms[site] = unifiAlarms
+	}
+	return unifiSiteAlarms
+}
+
+// EnrichEvents looks up the remote address of every event with a source or
+// destination IP.
+func (h *GeoIPHandler) EnrichEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	if h.city == nil && h.asn == nil {
+		return unifiSiteEvents
+	}
+	for site, unifiEvents := range unifiSiteEvents {
+		for i, unifiEvent := range unifiEvents.Events {
+			unifiEvents.Events[i].Remote = h.lookup(site, remoteIP(unifiEvent.SrcIP, unifiEvent.DestIP))
+		}
+		unifiSiteEvents[site] = unifiEvents
+	}
+	return unifiSiteEvents
+}
+
+func (h *GeoIPHandler) lookup(site string, ip net.IP) model.GeoIP {
+	if ip == nil {
+		return model.GeoIP{}
+	}
+
+	geoIP := model.GeoIP{IP: ip.String()}
+	if h.city != nil {
+		record := model.GeoIPCityRecord{}
+		err := h.city.Lookup(ip, &record)
+		if err != nil {
+			h.Logger.WithField("site", site).Warnf("could not look up the location of %s, error=%s", ip, err)
+		}
+		geoIP.Country = record.Country.ISOCode
+		geoIP.CountryName = record.Country.Names["en"]
+		geoIP.City = record.City.Names["en"]
+	}
+	if h.asn != nil {
+		record := model.GeoIPASNRecord{}
+		err := h.asn.Lookup(ip, &record)
+		if err != nil {
+			h.Logger.WithField("site", site).Warnf("could not look up the asn of %s, error=%s", ip, err)
+		}
+		geoIP.ASN = record.Number
+		geoIP.ASOrg = record.Organization
+	}
+	return geoIP
+}
+
+// remoteIP returns the first public address, nil when there is none.
+func remoteIP(ips ...string) net.IP {
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil || parsed.IsUnspecified() || parsed.IsMulticast() {
+			continue
+		}
+		if !inNetworks(privateNetworks, parsed) {
+			return parsed
+		}
+	}
+	return nil
+}
+
+func inNetworks(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
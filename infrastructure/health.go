@@ -0,0 +1,163 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+var subsystemNames = map[string]string{
+	"wan":  "WAN",
+	"www":  "Internet",
+	"wlan": "WLAN",
+	"lan":  "LAN",
+	"vpn":  "VPN",
+}
+
+// HealthMonitorHandler compares the health of the subsystems of every site
+// with the previous poll, catching ISP outages and degraded links the
+// controller logs no event for.
+type HealthMonitorHandler struct {
+	Config model.HealthConfig
+	Logger *logrus.Logger
+	health *healthStore
+}
+
+type healthStore struct {
+	sync.Mutex
+	subsystems map[string]*subsystemHealth
+}
+
+// subsystemHealth is what was wrong with a subsystem at the previous poll,
+// since is when it became unhealthy and zero when it already was when first
+// seen.
+type subsystemHealth struct {
+	problems []healthProblem
+	since    time.Time
+}
+
+// healthProblem kind is compared between polls so a latency that stays above
+// the threshold is only reported once.
+type healthProblem struct {
+	kind string
+	text string
+}
+
+func NewHealthMonitorHandler(config model.HealthConfig, logger *logrus.Logger) HealthMonitorHandler {
+	return HealthMonitorHandler{Config: config, Logger: logger, health: &healthStore{subsystems: map[string]*subsystemHealth{}}}
+}
+
+// CompareHealth returns an event for every subsystem that became unhealthy,
+// whose problems changed or that recovered since the previous poll.
+// Subsystems seen for the first time are only recorded.
+func (h *HealthMonitorHandler) CompareHealth(unifiSiteHealth model.UnifiSiteHealth, now time.Time) model.UnifiSiteEvents {
+	h.health.Lock()
+	defer h.health.Unlock()
+	unifiSiteEvents := model.UnifiSiteEvents{}
+	for site, unifiHealth := range unifiSiteHealth {
+		unifiEvents := model.UnifiEvents{SiteDescription: unifiHealth.SiteDescription, Controller: unifiHealth.Controller}
+		for _, subsystem := range unifiHealth.Subsystems {
+			if !containsString(h.Config.Subsystems, subsystem.Subsystem) {
+				continue
+			}
+			id := fmt.Sprintf("%s/%s/%s", unifiHealth.Controller, site, subsystem.Subsystem)
+			problems := h.problems(subsystem)
+			previous, ok := h.health.subsystems[id]
+			if !ok {
+				h.health.subsystems[id] = &subsystemHealth{problems: problems}
+				continue
+			}
+			if problemKinds(problems) == problemKinds(previous.problems) {
+				continue
+			}
+
+			h.Logger.WithField("site", site).Debugf("%s health changed from %q to %q", subsystem.Subsystem, problemKinds(previous.problems), problemKinds(problems))
+			if len(previous.problems) == 0 {
+				previous.since = now
+			}
+			unifiEvents.Events = append(unifiEvents.Events, h.healthEvent(subsystem, problems, previous, now))
+			previous.problems = problems
+		}
+		if len(unifiEvents.Events) > 0 {
+			unifiSiteEvents[site] = unifiEvents
+		}
+	}
+	return unifiSiteEvents
+}
+
+// problems lists what is wrong with a subsystem, nothing when it is healthy.
+func (h *HealthMonitorHandler) problems(subsystem model.UnifiSubsystemHealth) []healthProblem {
+	problems := []healthProblem{}
+	switch subsystem.Status {
+	case model.HealthStatusError:
+		problems = append(problems, healthProblem{kind: "down", text: "down"})
+	case model.HealthStatusWarning:
+		problems = append(problems, healthProblem{kind: "degraded", text: "degraded"})
+	}
+	if subsystem.NumDisconnected > 0 {
+		problems = append(problems, healthProblem{kind: "disconnected", text: fmt.Sprintf("%d devices disconnected", subsystem.NumDisconnected)})
+	}
+	latency := time.Duration(subsystem.Latency) * time.Millisecond
+	if h.Config.LatencyThreshold > 0 && latency > h.Config.LatencyThreshold {
+		problems = append(problems, healthProblem{kind: "latency", text: fmt.Sprintf("latency %s above %s", latency, h.Config.LatencyThreshold)})
+	}
+	if h.Config.DropsThreshold > 0 && subsystem.Drops > h.Config.DropsThreshold {
+		problems = append(problems, healthProblem{kind: "drops", text: fmt.Sprintf("%d drops above %d", subsystem.Drops, h.Config.DropsThreshold)})
+	}
+	return problems
+}
+
+func (h *HealthMonitorHandler) healthEvent(subsystem model.UnifiSubsystemHealth, problems []healthProblem, previous *subsystemHealth, now time.Time) model.UnifiEvent {
+	name, ok := subsystemNames[subsystem.Subsystem]
+	if !ok {
+		name = strings.ToUpper(subsystem.Subsystem)
+	}
+
+	var unifiEvent model.UnifiEvent
+	id := fmt.Sprintf("health-%s-%d", subsystem.Subsystem, now.Unix())
+	switch {
+	case len(problems) == 0 && previous.since.IsZero():
+		// unhealthy since before the first poll, how long is not known
+		unifiEvent = syntheticEvent(id, model.EventKeyHealthRecovered, now,
+			fmt.Sprintf("%s recovered (%s)", name, problemTexts(previous.problems)))
+	case len(problems) == 0:
+		unifiEvent = syntheticEvent(id, model.EventKeyHealthRecovered, now,
+			fmt.Sprintf("%s recovered after %s (%s)", name, humanDuration(now.Sub(previous.since)), problemTexts(previous.problems)))
+		unifiEvent.Duration = int64(now.Sub(previous.since) / time.Second)
+	default:
+		msg := fmt.Sprintf("%s has %s", name, problemTexts(problems))
+		if problems[0].kind == "down" || problems[0].kind == "degraded" {
+			msg = fmt.Sprintf("%s is %s", name, problemTexts(problems))
+		}
+		unifiEvent = syntheticEvent(id, model.EventKeyHealthDegraded, now, msg)
+		if problems[0].kind == "down" && (subsystem.Subsystem == "wan" || subsystem.Subsystem == "www") {
+			unifiEvent.Severity = model.SeverityCritical
+		}
+	}
+	unifiEvent.Gw, unifiEvent.GwName = strings.ToLower(subsystem.GwMac), subsystem.GwName
+	if subsystem.Subsystem == "wan" {
+		unifiEvent.IP = subsystem.WanIP
+	}
+	return unifiEvent
+}
+
+func problemKinds(problems []healthProblem) string {
+	kinds := make([]string, len(problems))
+	for i, problem := range problems {
+		kinds[i] = problem.kind
+	}
+	return strings.Join(kinds, ", ")
+}
+
+func problemTexts(problems []healthProblem) string {
+	texts := make([]string, len(problems))
+	for i, problem := range problems {
+		texts[i] = problem.text
+	}
+	return strings.Join(texts, ", ")
+}
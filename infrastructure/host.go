@@ -0,0 +1,223 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+var ipPattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+
+// reverseDNSLookups is how many reverse lookups run at the same time.
+const reverseDNSLookups = 16
+
+// HostHandler names the IPs in alarms and events, internal IPs by the client
+// holding them on the controller and any IP by reverse DNS.
+type HostHandler struct {
+	Config   model.HostConfig
+	Logger   *logrus.Logger
+	resolver *net.Resolver
+	cache    *hostCache
+}
+
+type hostCache struct {
+	sync.Mutex
+	hosts map[string]cachedHost
+}
+
+type cachedHost struct {
+	name    string
+	expires time.Time
+}
+
+type reverseLookup struct {
+	ip   string
+	name string
+	err  error
+}
+
+func NewHostHandler(config model.HostConfig, logger *logrus.Logger) (HostHandler, error) {
+	if config.Names != model.HostNamesAnnotate && config.Names != model.HostNamesReplace {
+		return HostHandler{}, fmt.Errorf("HOST_NAMES: invalid mode %q, must be %s or %s", config.Names, model.HostNamesAnnotate, model.HostNamesReplace)
+	}
+	h := HostHandler{Config: config, Logger: logger, resolver: net.DefaultResolver, cache: &hostCache{hosts: map[string]cachedHost{}}}
+	if config.ReverseDNSResolver != "" {
+		if _, _, err := net.SplitHostPort(config.ReverseDNSResolver); err != nil {
+			return HostHandler{}, fmt.Errorf("REVERSE_DNS_RESOLVER: invalid address %q, must be host:port", config.ReverseDNSResolver)
+		}
+		h.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				dialer := net.Dialer{Timeout: config.ReverseDNSTimeout}
+				return dialer.DialContext(ctx, network, config.ReverseDNSResolver)
+			},
+		}
+	}
+	return h, nil
+}
+
+// NameAlarms names the IPs of every alarm and annotates or replaces them in
+// its message.
+func (h *HostHandler) NameAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	for site, unifiAlarms := range unifiSiteAlarms {
+		ips := []string{}
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			ips = append(ips, ipPattern.FindAllString(unifiAlarm.Msg, -1)...)
+			ips = append(ips, unifiAlarm.SrcIP, unifiAlarm.DestIP)
+		}
+		hosts := h.hosts(site, unifiAlarms.Hosts, ips)
+		for i, unifiAlarm := range unifiAlarms.Alarms {
+			unifiAlarms.Alarms[i].Msg = h.replaceIPs(hosts, unifiAlarm.Msg)
+		}
+		unifiAlarms.Hosts = hosts
+		unifiSiteAlarms[site] = unifiAlarms
+	}
+	return unifiSiteAlarms
+}
+
+// NameEvents names the IPs of every event and annotates or replaces them in
+// its message.
+func (h *HostHandler) NameEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	for site, unifiEvents := range unifiSiteEvents {
+		ips := []string{}
+		for _, unifiEvent := range unifiEvents.Events {
+			ips = append(ips, ipPattern.FindAllString(unifiEvent.Msg, -1)...)
+			ips = append(ips, unifiEvent.IP, unifiEvent.SrcIP, unifiEvent.DestIP)
+		}
+		hosts := h.hosts(site, unifiEvents.Hosts, ips)
+		for i, unifiEvent := range unifiEvents.Events {
+			if unifiEvent.Subsystem != model.SyntheticSubsystem {
+				unifiEvents.Events[i].Msg = h.replaceIPs(hosts, unifiEvent.Msg)
+			}
+		}
+		unifiEvents.Hosts = hosts
+		unifiSiteEvents[site] = unifiEvents
+	}
+	return unifiSiteEvents
+}
+
+// hosts names the IPs of a site, internal IPs held by a client after the
+// client and the rest by reverse DNS.
+func (h *HostHandler) hosts(site string, clients map[string]string, ips []string) map[string]string {
+	hosts := map[string]string{}
+	lookups := []string{}
+	seen := map[string]bool{}
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil || parsed.IsUnspecified() || parsed.IsMulticast() || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		if name, ok := clients[ip]; ok && inNetworks(privateNetworks, parsed) {
+			hosts[ip] = name
+			continue
+		}
+		lookups = append(lookups, ip)
+	}
+	for ip, name := range h.lookup(site, lookups) {
+		hosts[ip] = name
+	}
+	return hosts
+}
+
+// lookup returns the reverse DNS names of IPs. The IPs that are not cached are
+// looked up in parallel, all within the reverse DNS timeout, so a burst of
+// alarms does not hold up the poll. Names and failed lookups are cached so
+// every IP is looked up at most once per cache TTL, lookups cut off by the
+// timeout are retried next time.
+func (h *HostHandler) lookup(site string, ips []string) map[string]string {
+	names := map[string]string{}
+	if !h.Config.ReverseDNS || len(ips) == 0 {
+		return names
+	}
+
+	now := time.Now()
+	pending := []string{}
+	h.cache.Lock()
+	for _, ip := range ips {
+		if cached, ok := h.cache.hosts[ip]; ok && now.Before(cached.expires) {
+			if cached.name != "" {
+				names[ip] = cached.name
+			}
+			continue
+		}
+		pending = append(pending, ip)
+	}
+	h.cache.Unlock()
+	if len(pending) == 0 {
+		return names
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.Config.ReverseDNSTimeout)
+	defer cancel()
+	results := make(chan reverseLookup, len(pending))
+	slots := make(chan struct{}, reverseDNSLookups)
+	for _, ip := range pending {
+		go func(ip string) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			names, err := h.resolver.LookupAddr(ctx, ip)
+			result := reverseLookup{ip: ip, err: err}
+			if err == nil && len(names) > 0 {
+				result.name = strings.TrimSuffix(names[0], ".")
+			}
+			results <- result
+		}(ip)
+	}
+
+	// wait without the lock so other pollers and the pruning are not blocked
+	looked := map[string]string{}
+	for range pending {
+		result := <-results
+		if result.err != nil {
+			h.Logger.WithField("site", site).Debugf("could not look up the name of %s, error=%s", result.ip, result.err)
+			if ctx.Err() != nil {
+				continue
+			}
+		}
+		looked[result.ip] = result.name
+		if result.name != "" {
+			names[result.ip] = result.name
+		}
+	}
+
+	h.cache.Lock()
+	defer h.cache.Unlock()
+	expires := time.Now().Add(h.Config.ReverseDNSCacheTTL)
+	for ip, name := range looked {
+		h.cache.hosts[ip] = cachedHost{name: name, expires: expires}
+	}
+	return names
+}
+
+// Prune drops the expired names from the cache.
+func (h *HostHandler) Prune(now time.Time) {
+	h.cache.Lock()
+	defer h.cache.Unlock()
+	for ip, cached := range h.cache.hosts {
+		if now.After(cached.expires) {
+			delete(h.cache.hosts, ip)
+		}
+	}
+}
+
+func (h *HostHandler) replaceIPs(hosts map[string]string, msg string) string {
+	return ipPattern.ReplaceAllStringFunc(msg, func(ip string) string {
+		name, ok := hosts[ip]
+		if !ok {
+			return ip
+		}
+		if h.Config.Names == model.HostNamesReplace {
+			return name
+		}
+		return fmt.Sprintf("%s (%s)", ip, name)
+	})
+}
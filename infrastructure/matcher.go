@@ -0,0 +1,323 @@
+package infrastructure
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+const (
+	SubjectTypeAlarm = "alarm"
+	SubjectTypeEvent = "event"
+)
+
+// subject is the part of an alarm or event a matcher looks at.
+type subject struct {
+	Type               string
+	Controller         string
+	Site               string
+	SiteDesc           string
+	Key                string
+	Subsystem          string
+	Catname            string
+	InnerAlertSeverity int64
+	Severity           model.Severity
+	MACs               []string
+	IPs                []string
+	SSID               string
+	Country            string
+	ASN                uint
+	Msg                string
+	Datetime           time.Time
+}
+
+type matcher struct {
+	model.Matcher
+	msg       *regexp.Regexp
+	cidrs     []*net.IPNet
+	timeOfDay *timeWindow
+}
+
+type timeWindow struct {
+	days     map[time.Weekday]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func newMatcher(config model.Matcher) (matcher, error) {
+	m := matcher{Matcher: config}
+	m.Countries = make([]string, len(config.Countries))
+	for i, country := range config.Countries {
+		m.Countries[i] = strings.ToUpper(country)
+	}
+	for _, patterns := range [][]string{config.Sites, config.Keys, config.Subsystems, config.Catnames, config.MACs, config.SSIDs, config.Controllers} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return matcher{}, fmt.Errorf("invalid pattern %q, error=%s", pattern, err)
+			}
+		}
+	}
+
+	for _, severity := range config.Severities {
+		if _, ok := severityRanks[severity]; !ok {
+			return matcher{}, fmt.Errorf("invalid severity %q, must be %s, %s or %s", severity, model.SeverityInfo, model.SeverityWarning, model.SeverityCritical)
+		}
+	}
+
+	for _, t := range config.Types {
+		if t != SubjectTypeAlarm && t != SubjectTypeEvent {
+			return matcher{}, fmt.Errorf("invalid type %q, must be %s or %s", t, SubjectTypeAlarm, SubjectTypeEvent)
+		}
+	}
+
+	if config.Msg != "" {
+		msg, err := regexp.Compile(config.Msg)
+		if err != nil {
+			return matcher{}, err
+		}
+		m.msg = msg
+	}
+
+	for _, cidr := range config.CIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return matcher{}, err
+		}
+		m.cidrs = append(m.cidrs, ipNet)
+	}
+
+	if config.TimeOfDay != nil {
+		timeOfDay, err := newTimeWindow(*config.TimeOfDay)
+		if err != nil {
+			return matcher{}, err
+		}
+		m.timeOfDay = &timeOfDay
+	}
+	return m, nil
+}
+
+func newTimeWindow(config model.TimeWindow) (timeWindow, error) {
+	w := timeWindow{days: map[time.Weekday]bool{}, location: time.Local}
+	for _, day := range config.Days {
+		day = strings.ToLower(day)
+		if len(day) > 3 {
+			day = day[:3]
+		}
+		weekday, ok := weekdays[day]
+		if !ok {
+			return timeWindow{}, fmt.Errorf("invalid day %q", day)
+		}
+		w.days[weekday] = true
+	}
+
+	var err error
+	w.start, err = parseClock(config.Start)
+	if err != nil {
+		return timeWindow{}, err
+	}
+	w.end, err = parseClock(config.End)
+	if err != nil {
+		return timeWindow{}, err
+	}
+
+	if config.Timezone != "" {
+		w.location, err = time.LoadLocation(config.Timezone)
+		if err != nil {
+			return timeWindow{}, err
+		}
+	}
+	return w, nil
+}
+
+// parseClock returns the minutes since midnight of a HH:MM time.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start <= w.end {
+		return w.onDay(day) && minute >= w.start && minute < w.end
+	}
+	// the window wraps past midnight so the early part belongs to the day before
+	if minute >= w.start {
+		return w.onDay(day)
+	}
+	return minute < w.end && w.onDay((day+6)%7)
+}
+
+func (w timeWindow) onDay(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+func (m matcher) match(s subject) bool {
+	if len(m.Types) > 0 && !containsString(m.Types, s.Type) {
+		return false
+	}
+	if len(m.Controllers) > 0 && !matchGlobs(m.Controllers, s.Controller) {
+		return false
+	}
+	if len(m.Sites) > 0 && !matchGlobs(m.Sites, s.Site, s.SiteDesc) {
+		return false
+	}
+	if len(m.Keys) > 0 && !matchGlobs(m.Keys, s.Key) {
+		return false
+	}
+	if len(m.Subsystems) > 0 && !matchGlobs(m.Subsystems, s.Subsystem) {
+		return false
+	}
+	if len(m.Catnames) > 0 && !matchGlobs(m.Catnames, s.Catname) {
+		return false
+	}
+	if len(m.InnerAlertSeverities) > 0 && !containsInt64(m.InnerAlertSeverities, s.InnerAlertSeverity) {
+		return false
+	}
+	if len(m.Severities) > 0 && !containsSeverity(m.Severities, s.Severity) {
+		return false
+	}
+	if len(m.MACs) > 0 && !matchGlobs(lower(m.MACs), lower(s.MACs)...) {
+		return false
+	}
+	if len(m.cidrs) > 0 && !m.matchIPs(s.IPs) {
+		return false
+	}
+	if len(m.SSIDs) > 0 && !matchGlobs(m.SSIDs, s.SSID) {
+		return false
+	}
+	if len(m.Countries) > 0 && !containsString(m.Countries, strings.ToUpper(s.Country)) {
+		return false
+	}
+	if len(m.ASNs) > 0 && !containsUint(m.ASNs, s.ASN) {
+		return false
+	}
+	if m.msg != nil && !m.msg.MatchString(s.Msg) {
+		return false
+	}
+	if m.timeOfDay != nil && !m.timeOfDay.contains(s.Datetime) {
+		return false
+	}
+	return true
+}
+
+func (m matcher) matchIPs(ips []string) bool {
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			continue
+		}
+		for _, cidr := range m.cidrs {
+			if cidr.Contains(parsed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func alarmSubject(controller string, site string, siteDesc string, unifiAlarm model.UnifiAlarm) subject {
+	return subject{
+		Type:               SubjectTypeAlarm,
+		Controller:         controller,
+		Site:               site,
+		SiteDesc:           siteDesc,
+		Key:                unifiAlarm.Key,
+		Subsystem:          unifiAlarm.Subsystem,
+		Catname:            unifiAlarm.Catname,
+		InnerAlertSeverity: unifiAlarm.InnerAlertSeverity,
+		Severity:           unifiAlarm.Severity,
+		MACs:               nonEmpty(unifiAlarm.SrcMAC, unifiAlarm.DstMAC, unifiAlarm.Ap, unifiAlarm.Gw),
+		IPs:                nonEmpty(unifiAlarm.SrcIP, unifiAlarm.DestIP),
+		Country:            unifiAlarm.Remote.Country,
+		ASN:                unifiAlarm.Remote.ASN,
+		Msg:                unifiAlarm.Msg,
+		Datetime:           unifiAlarm.Datetime,
+	}
+}
+
+func eventSubject(controller string, site string, siteDesc string, unifiEvent model.UnifiEvent) subject {
+	return subject{
+		Type:               SubjectTypeEvent,
+		Controller:         controller,
+		Site:               site,
+		SiteDesc:           siteDesc,
+		Key:                unifiEvent.Key,
+		Subsystem:          unifiEvent.Subsystem,
+		Catname:            unifiEvent.Catname,
+		InnerAlertSeverity: unifiEvent.InnerAlertSeverity,
+		Severity:           unifiEvent.Severity,
+		MACs:               nonEmpty(unifiEvent.User, unifiEvent.Ap, unifiEvent.Gw, unifiEvent.Sw, unifiEvent.SrcMAC, unifiEvent.DstMAC, unifiEvent.ApFrom, unifiEvent.ApTo),
+		IPs:                nonEmpty(unifiEvent.IP, unifiEvent.SrcIP, unifiEvent.DestIP),
+		SSID:               unifiEvent.SSID,
+		Country:            unifiEvent.Remote.Country,
+		ASN:                unifiEvent.Remote.ASN,
+		Msg:                unifiEvent.Msg,
+		Datetime:           unifiEvent.Datetime,
+	}
+}
+
+func nonEmpty(values ...string) []string {
+	nonEmptyValues := []string{}
+	for _, value := range values {
+		if value != "" {
+			nonEmptyValues = append(nonEmptyValues, value)
+		}
+	}
+	return nonEmptyValues
+}
+
+func lower(values []string) []string {
+	lowerValues := make([]string, len(values))
+	for i, value := range values {
+		lowerValues[i] = strings.ToLower(value)
+	}
+	return lowerValues
+}
+
+func containsInt64(values []int64, value int64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSeverity(values []model.Severity, value model.Severity) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint(values []uint, value uint) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+type MentionHandler struct {
+	Config model.MentionConfig
+	Logger *logrus.Logger
+	rules  []mentionRule
+}
+
+type mentionRule struct {
+	model.MentionRule
+	matcher matcher
+}
+
+func NewMentionHandler(config model.MentionConfig, logger *logrus.Logger) (MentionHandler, error) {
+	h := MentionHandler{Config: config, Logger: logger}
+	if err := validateMention(config.Default); err != nil {
+		return MentionHandler{}, fmt.Errorf("MENTION_DEFAULT: %s", err)
+	}
+	if _, ok := severityRanks[config.MinSeverity]; !ok {
+		return MentionHandler{}, fmt.Errorf("MENTION_MIN_SEVERITY: invalid severity %q, must be %s, %s or %s", config.MinSeverity, model.SeverityInfo, model.SeverityWarning, model.SeverityCritical)
+	}
+	if config.File == "" {
+		return h, nil
+	}
+
+	rulesBytes, err := ioutil.ReadFile(config.File)
+	if err != nil {
+		return MentionHandler{}, err
+	}
+
+	rules := []model.MentionRule{}
+	err = json.Unmarshal(rulesBytes, &rules)
+	if err != nil {
+		return MentionHandler{}, fmt.Errorf("could not parse mentions file %s, error=%s", config.File, err)
+	}
+
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Mention == "" {
+			rule.Mention = model.MentionNone
+		}
+		if err := validateMention(rule.Mention); err != nil {
+			return MentionHandler{}, fmt.Errorf("mention %s: %s", rule.Name, err)
+		}
+		if _, ok := severityRanks[rule.MinSeverity]; !ok && rule.MinSeverity != "" {
+			return MentionHandler{}, fmt.Errorf("mention %s: invalid min_severity %q, must be %s, %s or %s", rule.Name, rule.MinSeverity, model.SeverityInfo, model.SeverityWarning, model.SeverityCritical)
+		}
+		m, err := newMatcher(rule.Matcher)
+		if err != nil {
+			return MentionHandler{}, fmt.Errorf("mention %s: %s", rule.Name, err)
+		}
+		h.rules = append(h.rules, mentionRule{MentionRule: rule, matcher: m})
+	}
+	logger.Infof("loaded %d mention rules from %s", len(h.rules), config.File)
+	return h, nil
+}
+
+func validateMention(mention string) error {
+	if mention != model.MentionHere && mention != model.MentionChannel && mention != model.MentionNone {
+		return fmt.Errorf("invalid mention %q, must be %s, %s or %s", mention, model.MentionHere, model.MentionChannel, model.MentionNone)
+	}
+	return nil
+}
+
+// MentionAlarms decides who each alarm notifies.
+func (h *MentionHandler) MentionAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	for site, unifiAlarms := range unifiSiteAlarms {
+		for i, unifiAlarm := range unifiAlarms.Alarms {
+			unifiAlarms.Alarms[i].Mention = h.mention(alarmSubject(unifiAlarms.Controller, site, unifiAlarms.SiteDescription, unifiAlarm), unifiAlarm.ID)
+		}
+		unifiSiteAlarms[site] = unifiAlarms
+	}
+	return unifiSiteAlarms
+}
+
+// MentionEvents decides who each event notifies.
+func (h *MentionHandler) MentionEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	for site, unifiEvents := range unifiSiteEvents {
+		for i, unifiEvent := range unifiEvents.Events {
+			unifiEvents.Events[i].Mention = h.mention(eventSubject(unifiEvents.Controller, site, unifiEvents.SiteDescription, unifiEvent), unifiEvent.ID)
+		}
+		unifiSiteEvents[site] = unifiEvents
+	}
+	return unifiSiteEvents
+}
+
+// mention evaluates the rules in order, the first rule matching the subject at
+// or above its minimum severity decides. Without a matching rule the default
+// mention is used from the minimum severity up.
+func (h *MentionHandler) mention(s subject, id string) model.Mention {
+	for _, rule := range h.rules {
+		if !atLeast(s.Severity, rule.MinSeverity) || !rule.matcher.match(s) {
+			continue
+		}
+		h.Logger.WithFields(logrus.Fields{"site": s.Site, "type": s.Type}).Debugf("mention %s matched %s %s, mention=%s", rule.Name, s.Key, id, rule.Mention)
+		mention := model.Mention{Users: rule.Users, Groups: rule.Groups}
+		if rule.Mention != model.MentionNone {
+			mention.Broadcast = rule.Mention
+		}
+		return mention
+	}
+	if h.Config.Default != model.MentionNone && atLeast(s.Severity, h.Config.MinSeverity) {
+		return model.Mention{Broadcast: h.Config.Default}
+	}
+	return model.Mention{}
+}
+
+func atLeast(severity model.Severity, min model.Severity) bool {
+	return severityRanks[severity] >= severityRanks[min]
+}
@@ -0,0 +1,147 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// offlineStates are the states a device is considered down in, the other
+// states it is only busy in.
+var offlineStates = map[int64]bool{
+	model.DeviceStateDisconnected:    true,
+	model.DeviceStateHeartbeatMissed: true,
+	model.DeviceStateIsolated:        true,
+}
+
+// DeviceMonitorHandler compares the state of every device with the previous
+// poll, catching the outages the controller has no event for, e.g. because it
+// was restarted while the device was down.
+type DeviceMonitorHandler struct {
+	Config  model.DeviceMonitorConfig
+	Logger  *logrus.Logger
+	devices *deviceStateStore
+}
+
+type deviceStateStore struct {
+	sync.Mutex
+	states map[string]*deviceState
+}
+
+// deviceState is the last known state of a device, since is when it left the
+// connected state.
+type deviceState struct {
+	state int64
+	since time.Time
+	seen  bool
+}
+
+func NewDeviceMonitorHandler(config model.DeviceMonitorConfig, logger *logrus.Logger) DeviceMonitorHandler {
+	return DeviceMonitorHandler{Config: config, Logger: logger, devices: &deviceStateStore{states: map[string]*deviceState{}}}
+}
+
+// CompareDevices returns an event for every device whose state changed since
+// the previous poll. Devices seen for the first time are only recorded.
+func (h *DeviceMonitorHandler) CompareDevices(unifiSiteDevices model.UnifiSiteDevices, now time.Time) model.UnifiSiteEvents {
+	h.devices.Lock()
+	defer h.devices.Unlock()
+	for _, state := range h.devices.states {
+		state.seen = false
+	}
+
+	unifiSiteEvents := model.UnifiSiteEvents{}
+	for site, unifiDevices := range unifiSiteDevices {
+		unifiEvents := model.UnifiEvents{SiteDescription: unifiDevices.SiteDescription, Controller: unifiDevices.Controller}
+		for _, unifiDevice := range unifiDevices.Devices {
+			mac := strings.ToLower(unifiDevice.Mac)
+			id := fmt.Sprintf("%s/%s/%s", unifiDevices.Controller, site, mac)
+			previous, ok := h.devices.states[id]
+			if !ok {
+				previous = &deviceState{state: unifiDevice.State, since: lastSeen(unifiDevice, now)}
+				h.devices.states[id] = previous
+			}
+			previous.seen = true
+			if !ok || previous.state == unifiDevice.State {
+				continue
+			}
+
+			h.Logger.WithField("site", site).Debugf("device %s changed state from %d to %d", mac, previous.state, unifiDevice.State)
+			unifiEvent := h.stateEvent(unifiDevice, mac, previous, now)
+			unifiEvents.Events = append(unifiEvents.Events, unifiEvent)
+			if previous.state == model.DeviceStateConnected {
+				previous.since = lastSeen(unifiDevice, now)
+			}
+			previous.state = unifiDevice.State
+		}
+		if len(unifiEvents.Events) > 0 {
+			unifiSiteEvents[site] = unifiEvents
+		}
+	}
+
+	// forget the devices removed from the controller, only for the controllers
+	// that were polled
+	for id, state := range h.devices.states {
+		for site, unifiDevices := range unifiSiteDevices {
+			if !state.seen && strings.HasPrefix(id, fmt.Sprintf("%s/%s/", unifiDevices.Controller, site)) {
+				delete(h.devices.states, id)
+			}
+		}
+	}
+	return unifiSiteEvents
+}
+
+func (h *DeviceMonitorHandler) stateEvent(unifiDevice model.UnifiDevice, mac string, previous *deviceState, now time.Time) model.UnifiEvent {
+	device := mac
+	if unifiDevice.Name != "" {
+		device = fmt.Sprintf("%s (%s)", unifiDevice.Name, mac)
+	}
+
+	key := model.EventKeyDeviceStateChanged
+	msg := fmt.Sprintf("Device %s is %s", device, deviceStateName(unifiDevice.State))
+	switch {
+	case unifiDevice.State == model.DeviceStateConnected:
+		key = model.EventKeyDeviceOnline
+		msg = fmt.Sprintf("Device %s is back online after %s (%s)", device, humanDuration(now.Sub(previous.since)), deviceStateName(previous.state))
+		if !offlineStates[previous.state] {
+			msg = fmt.Sprintf("Device %s is connected again after %s for %s", device, deviceStateName(previous.state), humanDuration(now.Sub(previous.since)))
+		}
+	case offlineStates[unifiDevice.State] && !offlineStates[previous.state]:
+		key = model.EventKeyDeviceOffline
+		msg = fmt.Sprintf("Device %s is offline (%s)", device, deviceStateName(unifiDevice.State))
+	}
+
+	unifiEvent := syntheticEvent(fmt.Sprintf("device-%s-%d-%d", mac, unifiDevice.State, now.Unix()), key, now, msg)
+	switch {
+	case unifiDevice.Type == "uap":
+		unifiEvent.Ap, unifiEvent.ApName = mac, unifiDevice.Name
+	case unifiDevice.Type == "usw":
+		unifiEvent.Sw, unifiEvent.SwName = mac, unifiDevice.Name
+	case containsString(gatewayTypes, unifiDevice.Type):
+		unifiEvent.Gw, unifiEvent.GwName = mac, unifiDevice.Name
+	}
+	if unifiDevice.State == model.DeviceStateConnected {
+		unifiEvent.Duration = int64(now.Sub(previous.since) / time.Second)
+	}
+	return unifiEvent
+}
+
+// lastSeen is when the controller last heard from a device, or now when it
+// does not say.
+func lastSeen(unifiDevice model.UnifiDevice, now time.Time) time.Time {
+	if unifiDevice.LastSeen == 0 || unifiDevice.State == model.DeviceStateConnected {
+		return now
+	}
+	return time.Unix(unifiDevice.LastSeen, 0)
+}
+
+func deviceStateName(state int64) string {
+	if name, ok := model.DeviceStateNames[state]; ok {
+		return name
+	}
+	return fmt.Sprintf("in state %d", state)
+}
@@ -0,0 +1,190 @@
+package infrastructure
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+var macPattern = regexp.MustCompile(`(?i)\b[0-9a-f]{2}(?::[0-9a-f]{2}){5}\b`)
+
+// NameHandler gives every MAC a human readable name, in order of preference
+// an alias from the aliases file, the name of the device or client on the
+// controller, the vendor the controller knows the client by and finally the
+// vendor of the OUI.
+type NameHandler struct {
+	Config  model.NameConfig
+	Logger  *logrus.Logger
+	aliases map[string]string
+	ouis    map[string]string
+}
+
+func NewNameHandler(config model.NameConfig, logger *logrus.Logger) (NameHandler, error) {
+	h := NameHandler{Config: config, Logger: logger, aliases: map[string]string{}, ouis: generatedOUIs}
+	if config.AliasesFile != "" {
+		file, err := os.Open(config.AliasesFile)
+		if err != nil {
+			return NameHandler{}, err
+		}
+		defer file.Close()
+
+		aliases := map[string]string{}
+		err = json.NewDecoder(file).Decode(&aliases)
+		if err != nil {
+			return NameHandler{}, fmt.Errorf("could not parse aliases file %s, error=%s", config.AliasesFile, err)
+		}
+		for mac, alias := range aliases {
+			if !macPattern.MatchString(mac) || len(mac) != 17 {
+				return NameHandler{}, fmt.Errorf("alias %s: invalid mac %q", alias, mac)
+			}
+			h.aliases[strings.ToLower(mac)] = alias
+		}
+		logger.Infof("loaded %d aliases from %s", len(h.aliases), config.AliasesFile)
+	}
+	if config.OUIFile != "" {
+		ouis, err := loadOUIs(config.OUIFile)
+		if err != nil {
+			return NameHandler{}, fmt.Errorf("could not parse oui file %s, error=%s", config.OUIFile, err)
+		}
+		h.ouis = ouis
+		logger.Infof("loaded %d ouis from %s", len(h.ouis), config.OUIFile)
+	} else {
+		logger.Debugf("using the %d bundled ouis", len(h.ouis))
+	}
+	return h, nil
+}
+
+// loadOUIs reads the IEEE MA-L registry, either oui.csv or oui.txt.
+func loadOUIs(ouiFile string) (map[string]string, error) {
+	file, err := os.Open(ouiFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ouis := map[string]string{}
+	if strings.EqualFold(filepath.Ext(ouiFile), ".csv") {
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			// Registry,Assignment,Organization Name,Organization Address
+			if len(record) < 3 || record[0] == "Registry" {
+				continue
+			}
+			if oui := ouiOf(record[1]); oui != "" {
+				ouis[oui] = strings.TrimSpace(record[2])
+			}
+		}
+		return ouis, nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 00-15-6D   (hex)		Ubiquiti Networks Inc.
+		parts := strings.SplitN(scanner.Text(), "(hex)", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if oui := ouiOf(strings.TrimSpace(parts[0])); oui != "" {
+			ouis[oui] = strings.TrimSpace(parts[1])
+		}
+	}
+	return ouis, scanner.Err()
+}
+
+// NameAlarms replaces the MACs in the message of every alarm with their name
+// and keeps the names of the MACs of the alarms for the templates. Only the
+// MACs of the alarms are named, not the whole inventory.
+func (h *NameHandler) NameAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	for site, unifiAlarms := range unifiSiteAlarms {
+		names := map[string]string{}
+		for i, unifiAlarm := range unifiAlarms.Alarms {
+			h.addNames(names, unifiAlarms.Names, unifiAlarms.Vendors, append(macPattern.FindAllString(unifiAlarm.Msg, -1), unifiAlarm.SrcMAC, unifiAlarm.DstMAC, unifiAlarm.Ap, unifiAlarm.Gw)...)
+			unifiAlarms.Alarms[i].Msg = replaceMACs(names, unifiAlarm.Msg)
+		}
+		unifiAlarms.Names = names
+		unifiSiteAlarms[site] = unifiAlarms
+	}
+	return unifiSiteAlarms
+}
+
+// NameEvents replaces the MACs in the message of every event with their name
+// and keeps the names of the MACs of the events for the templates. Only the
+// MACs of the events are named, not the whole inventory.
+func (h *NameHandler) NameEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	for site, unifiEvents := range unifiSiteEvents {
+		names := map[string]string{}
+		for i, unifiEvent := range unifiEvents.Events {
+			h.addNames(names, unifiEvents.Names, unifiEvents.Vendors, append(macPattern.FindAllString(unifiEvent.Msg, -1), unifiEvent.User, unifiEvent.Ap, unifiEvent.Gw, unifiEvent.Sw, unifiEvent.SrcMAC, unifiEvent.DstMAC, unifiEvent.ApFrom, unifiEvent.ApTo)...)
+			// synthesized events already name their devices
+			if unifiEvent.Subsystem != model.SyntheticSubsystem {
+				unifiEvents.Events[i].Msg = replaceMACs(names, unifiEvent.Msg)
+			}
+		}
+		unifiEvents.Names = names
+		unifiSiteEvents[site] = unifiEvents
+	}
+	return unifiSiteEvents
+}
+
+func (h *NameHandler) addNames(names map[string]string, controllerNames map[string]string, vendors map[string]string, macs ...string) {
+	for _, mac := range macs {
+		mac = strings.ToLower(mac)
+		if mac == "" {
+			continue
+		}
+		if name := h.name(controllerNames, vendors, mac); name != "" {
+			names[mac] = name
+		}
+	}
+}
+
+func (h *NameHandler) name(controllerNames map[string]string, vendors map[string]string, mac string) string {
+	if alias, ok := h.aliases[mac]; ok {
+		return alias
+	}
+	if name, ok := controllerNames[mac]; ok {
+		return name
+	}
+	vendor := h.Vendor(vendors, mac)
+	if vendor == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s device (%s)", vendor, mac)
+}
+
+func replaceMACs(names map[string]string, msg string) string {
+	return macPattern.ReplaceAllStringFunc(msg, func(mac string) string {
+		if name, ok := names[strings.ToLower(mac)]; ok {
+			return name
+		}
+		return mac
+	})
+}
+
+// Vendor returns the vendor the controller knows a client by, or the vendor
+// of its OUI.
+func (h *NameHandler) Vendor(vendors map[string]string, mac string) string {
+	mac = strings.ToLower(mac)
+	if vendor, ok := vendors[mac]; ok {
+		return vendor
+	}
+	return h.ouis[ouiOf(mac)]
+}
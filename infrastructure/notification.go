@@ -0,0 +1,204 @@
+package infrastructure
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// NotificationHandler names, enriches and classifies alarms and events and runs them
+// through filtering, schedules, flood protection and digests before routing
+// them to their receivers.
+type NotificationHandler struct {
+	Names    NameHandler
+	Hosts    HostHandler
+	Clients  NewClientHandler
+	GeoIP    GeoIPHandler
+	Severity SeverityHandler
+	Filter   FilterHandler
+	Schedule ScheduleHandler
+	Flap     FlapHandler
+	Throttle ThrottleHandler
+	Digest   DigestHandler
+	Mention  MentionHandler
+	Route    RouteHandler
+	Logger   *logrus.Logger
+}
+
+func NewNotificationHandler(nameHandler NameHandler, hostHandler HostHandler, newClientHandler NewClientHandler, geoIPHandler GeoIPHandler, severityHandler SeverityHandler, filterHandler FilterHandler, scheduleHandler ScheduleHandler, flapHandler FlapHandler, throttleHandler ThrottleHandler, digestHandler DigestHandler, mentionHandler MentionHandler, routeHandler RouteHandler, logger *logrus.Logger) NotificationHandler {
+	return NotificationHandler{Names: nameHandler, Hosts: hostHandler, Clients: newClientHandler, GeoIP: geoIPHandler, Severity: severityHandler, Filter: filterHandler, Schedule: scheduleHandler, Flap: flapHandler, Throttle: throttleHandler, Digest: digestHandler, Mention: mentionHandler, Route: routeHandler, Logger: logger}
+}
+
+func (h *NotificationHandler) NotifyAlarms(unifiSiteAlarms model.UnifiSiteAlarms) error {
+	unifiSiteAlarms = h.Names.NameAlarms(unifiSiteAlarms)
+	unifiSiteAlarms = h.Hosts.NameAlarms(unifiSiteAlarms)
+	unifiSiteAlarms = h.GeoIP.EnrichAlarms(unifiSiteAlarms)
+	unifiSiteAlarms = h.Severity.ClassifyAlarms(unifiSiteAlarms)
+	unifiSiteAlarms = h.Filter.FilterAlarms(unifiSiteAlarms)
+	unifiSiteAlarms = h.Schedule.ScheduleAlarms(unifiSiteAlarms)
+	count := 0
+	for _, unifiAlarms := range unifiSiteAlarms {
+		count += len(unifiAlarms.Alarms)
+	}
+	noticeErr := h.observe(count, false)
+	unifiSiteAlarms = h.Digest.DigestAlarms(unifiSiteAlarms)
+
+	for _, unifiAlarms := range unifiSiteAlarms {
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			h.Logger.WithField("type", "alarm").Infof("%s %s", unifiAlarm.Msg, unifiAlarm.Datetime.String())
+		}
+	}
+	unifiSiteAlarms = h.Mention.MentionAlarms(unifiSiteAlarms)
+	return joinErrors(noticeErr, h.Route.NotifyAlarms(unifiSiteAlarms))
+}
+
+func (h *NotificationHandler) NotifyEvents(unifiSiteEvents model.UnifiSiteEvents) error {
+	unifiSiteEvents = h.Names.NameEvents(unifiSiteEvents)
+	unifiSiteEvents = h.Hosts.NameEvents(unifiSiteEvents)
+	unifiSiteEvents = h.Clients.DetectEvents(unifiSiteEvents)
+	unifiSiteEvents = h.GeoIP.EnrichEvents(unifiSiteEvents)
+	unifiSiteEvents = h.Severity.ClassifyEvents(unifiSiteEvents)
+	unifiSiteEvents = h.Filter.FilterEvents(unifiSiteEvents)
+	unifiSiteEvents = h.Schedule.ScheduleEvents(unifiSiteEvents)
+	unifiSiteEvents = h.Flap.HoldEvents(unifiSiteEvents)
+	count := 0
+	for _, unifiEvents := range unifiSiteEvents {
+		count += len(unifiEvents.Events)
+	}
+	noticeErr := h.observe(count, false)
+	unifiSiteEvents = h.Digest.DigestEvents(unifiSiteEvents)
+	return joinErrors(noticeErr, h.notifyEvents(unifiSiteEvents))
+}
+
+// Flush sends the lost contact events whose grace period has ended, what was
+// queued by schedules that are no longer active and the digests whose window
+// has ended, or everything pending when force is set, along with the counts of
+// throttled notifications. The batches receivers failed to send are retried
+// and expired host names are dropped from the cache.
+func (h *NotificationHandler) Flush(force bool) error {
+	var errs []string
+	err := h.observe(0, force)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, unifiSiteEvents := range h.Schedule.Flush(time.Now(), force) {
+		err := h.notifyEvents(unifiSiteEvents)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, unifiSiteEvents := range h.Flap.Flush(time.Now(), force) {
+		err := h.notifyEvents(h.Digest.DigestEvents(unifiSiteEvents))
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, unifiSiteEvents := range h.Digest.Flush(time.Now(), force) {
+		err := h.notifyEvents(unifiSiteEvents)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	h.Hosts.Prune(time.Now())
+	err = h.Route.RetryFailed()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	err = h.flushSuppressed()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func (h *NotificationHandler) notifyEvents(unifiSiteEvents model.UnifiSiteEvents) error {
+	// classify the events synthesized along the way
+	unifiSiteEvents = h.Severity.ClassifyEvents(unifiSiteEvents)
+	for _, unifiEvents := range unifiSiteEvents {
+		for _, unifiEvent := range unifiEvents.Events {
+			h.Logger.WithField("type", "event").Infof("%s %s", unifiEvent.Msg, unifiEvent.Datetime.String())
+		}
+	}
+	unifiSiteEvents = h.Mention.MentionEvents(unifiSiteEvents)
+	return h.Route.NotifyEvents(unifiSiteEvents)
+}
+
+// flushSuppressed tells each receiver how many of its notifications were
+// throttled since the last flush. The summaries are filtered, scheduled and
+// mentioned like other events and go to the receiver they were throttled for
+// without being throttled again.
+func (h *NotificationHandler) flushSuppressed() error {
+	var errs []string
+	for receiver, summaries := range h.Throttle.Suppressed(time.Now()) {
+		for _, unifiSiteEvents := range summaries {
+			unifiSiteEvents = h.Severity.ClassifyEvents(unifiSiteEvents)
+			unifiSiteEvents = h.Filter.FilterEvents(unifiSiteEvents)
+			unifiSiteEvents = h.Schedule.ScheduleEvents(unifiSiteEvents)
+			count := 0
+			for _, unifiEvents := range unifiSiteEvents {
+				count += len(unifiEvents.Events)
+			}
+			if count == 0 {
+				continue
+			}
+			unifiSiteEvents = h.Mention.MentionEvents(unifiSiteEvents)
+			err := h.Route.NotifyReceiver(receiver, unifiSiteEvents)
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// observe feeds the circuit breaker. While it is tripped everything but the
+// immediate keys goes to the digest, once it resets the pending digests are
+// sent straight away.
+func (h *NotificationHandler) observe(count int, force bool) error {
+	tripped, notice := h.Throttle.Observe(count, time.Now())
+	if notice == nil {
+		return nil
+	}
+
+	h.Digest.Force(tripped)
+	var errs []string
+	if !tripped && !force {
+		for _, unifiSiteEvents := range h.Digest.Flush(time.Now(), true) {
+			err := h.notifyEvents(unifiSiteEvents)
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	err := h.notifyEvents(notice)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func joinErrors(errs ...error) error {
+	messages := []string{}
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package infrastructure
+
+import "strings"
+
+//go:generate go run gen_oui.go -o oui_generated.go
+
+// ouiOf returns the first three octets of a MAC, or of an IEEE assignment
+// such as 00-15-6D or 00156D, as six upper case hex digits.
+func ouiOf(mac string) string {
+	hex := strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToUpper(mac))
+	if len(hex) < 6 {
+		return ""
+	}
+	return hex[:6]
+}
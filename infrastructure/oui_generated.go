@@ -0,0 +1,269 @@
+// Code generated by gen_oui.go from /tmp/oui_seed.csv; DO NOT EDIT.
+
+package infrastructure
+
+// generatedOUIs maps the upper case hex OUIs of the IEEE MA-L registry to
+// the organization they are assigned to.
+var generatedOUIs = map[string]string{
+	"00000C": "Cisco",
+	"000393": "Apple",
+	"00041F": "Sony",
+	"000569": "VMware",
+	"0009BF": "Nintendo",
+	"000A27": "Apple",
+	"000A95": "Apple",
+	"000C29": "VMware",
+	"000E58": "Sonos",
+	"001132": "Synology",
+	"0012FB": "Samsung",
+	"001422": "Dell",
+	"00146C": "Netgear",
+	"00155D": "Microsoft",
+	"00156D": "Ubiquiti",
+	"001599": "Samsung",
+	"001632": "Samsung",
+	"0016CB": "Apple",
+	"001788": "Philips Hue",
+	"0017F2": "Apple",
+	"001A92": "ASUSTek",
+	"001B21": "Intel",
+	"001B63": "Apple",
+	"001BA9": "Brother",
+	"001D25": "Samsung",
+	"001E0B": "Hewlett Packard",
+	"001E75": "LG",
+	"001E8F": "Canon",
+	"001EC2": "Apple",
+	"001F32": "Nintendo",
+	"001F5B": "Apple",
+	"002119": "Samsung",
+	"002312": "Apple",
+	"002339": "Samsung",
+	"0024E4": "Withings",
+	"002500": "Apple",
+	"002608": "Apple",
+	"0026BB": "Apple",
+	"002722": "Ubiquiti",
+	"005056": "VMware",
+	"00D9D1": "Sony",
+	"00E0FC": "Huawei",
+	"0403D6": "Nintendo",
+	"040CCE": "Apple",
+	"041552": "Apple",
+	"0418D6": "Ubiquiti",
+	"042665": "Apple",
+	"04D4C4": "ASUSTek",
+	"080581": "Roku",
+	"08D42B": "Samsung",
+	"0C47C9": "Amazon",
+	"101DC0": "Samsung",
+	"10604B": "Hewlett Packard",
+	"10683F": "LG",
+	"147DDA": "Apple",
+	"14CC20": "TP-Link",
+	"180373": "Dell",
+	"180CAC": "Canon",
+	"18B430": "Nest",
+	"18E829": "Ubiquiti",
+	"1C3BF3": "TP-Link",
+	"1CF29A": "Google",
+	"204E7F": "Netgear",
+	"20DFB9": "Google",
+	"240AC4": "Espressif",
+	"245A4C": "Ubiquiti",
+	"245EBE": "QNAP",
+	"246F28": "Espressif",
+	"24A43C": "Ubiquiti",
+	"281878": "Microsoft",
+	"286C07": "Xiaomi",
+	"286ED4": "Huawei",
+	"28CDC1": "Raspberry Pi",
+	"28CFE9": "Apple",
+	"2C56DC": "ASUSTek",
+	"2CAA8E": "Wyze",
+	"2CCF67": "Raspberry Pi",
+	"30055C": "Brother",
+	"30AEA4": "Espressif",
+	"30DE4B": "TP-Link",
+	"3413E8": "Intel",
+	"3423BA": "Samsung",
+	"347E5C": "Sonos",
+	"34D270": "Amazon",
+	"38F73D": "Amazon",
+	"3C0754": "Apple",
+	"3C15C2": "Apple",
+	"3C5AB4": "Google",
+	"3C71BF": "Espressif",
+	"3C846A": "TP-Link",
+	"3CA9F4": "Intel",
+	"3CD92B": "Hewlett Packard",
+	"406C8F": "Apple",
+	"40B4CD": "Amazon",
+	"40F520": "Espressif",
+	"442A60": "Apple",
+	"446132": "ecobee",
+	"44650D": "Amazon",
+	"44D9E7": "Ubiquiti",
+	"4846FB": "Huawei",
+	"4851B7": "Intel",
+	"48A6B8": "Sonos",
+	"48D6D5": "Google",
+	"4CEFC0": "Amazon",
+	"4CFCAA": "Tesla",
+	"5001BB": "Samsung",
+	"50465D": "ASUSTek",
+	"50C7BF": "TP-Link",
+	"50D4F7": "TP-Link",
+	"50EC50": "Xiaomi",
+	"50F5DA": "Amazon",
+	"542A1B": "Sonos",
+	"546009": "Google",
+	"54AF97": "TP-Link",
+	"5855CA": "Apple",
+	"5C0A5B": "Samsung",
+	"5C879C": "Intel",
+	"5CAAFD": "Sonos",
+	"5CCF7F": "Espressif",
+	"600194": "Espressif",
+	"6032B1": "TP-Link",
+	"6045BD": "Microsoft",
+	"60E327": "TP-Link",
+	"60FB42": "Apple",
+	"640980": "Xiaomi",
+	"641666": "Nest",
+	"64995D": "LG",
+	"64B9E8": "Apple",
+	"6837E9": "Amazon",
+	"6854FD": "Amazon",
+	"687251": "Ubiquiti",
+	"68A86D": "Apple",
+	"68C63A": "Espressif",
+	"68D79A": "Ubiquiti",
+	"705681": "Apple",
+	"709E29": "Sony",
+	"747548": "Amazon",
+	"7483C2": "Ubiquiti",
+	"74ACB9": "Ubiquiti",
+	"74C246": "Amazon",
+	"7811DC": "Xiaomi",
+	"781FDB": "Samsung",
+	"7828CA": "Sonos",
+	"784558": "Ubiquiti",
+	"788A20": "Ubiquiti",
+	"78CA39": "Apple",
+	"78E103": "Amazon",
+	"7C1E52": "Microsoft",
+	"7C49EB": "Xiaomi",
+	"7C6D62": "Apple",
+	"7C7A91": "Intel",
+	"7C9EBD": "Espressif",
+	"7CBB8A": "Nintendo",
+	"802AA8": "Ubiquiti",
+	"840D8E": "Espressif",
+	"8425DB": "Samsung",
+	"84D6D0": "Amazon",
+	"84F3EB": "Espressif",
+	"8866A5": "Apple",
+	"8871E5": "Amazon",
+	"8C7712": "Samsung",
+	"8C8590": "Apple",
+	"8C8D28": "Intel",
+	"8CAAB5": "Espressif",
+	"907240": "Apple",
+	"94103E": "Belkin",
+	"94350A": "Samsung",
+	"94652D": "OnePlus",
+	"949F3E": "Sonos",
+	"9801A7": "Apple",
+	"98B6E9": "Nintendo",
+	"98DAC4": "TP-Link",
+	"98F4AB": "Espressif",
+	"9C8E99": "Hewlett Packard",
+	"9CB6D0": "Intel",
+	"A002DC": "Amazon",
+	"A00BBA": "Samsung",
+	"A020A6": "Espressif",
+	"A040A0": "Netgear",
+	"A088B4": "Intel",
+	"A0D3C1": "Hewlett Packard",
+	"A45E60": "Apple",
+	"A47733": "Google",
+	"A4CF12": "Espressif",
+	"A823FE": "LG",
+	"A8667F": "Apple",
+	"AC220B": "ASUSTek",
+	"AC3A7A": "Roku",
+	"AC63BE": "Amazon",
+	"AC84C6": "TP-Link",
+	"AC87A3": "Apple",
+	"AC8BA9": "Ubiquiti",
+	"ACBC32": "Apple",
+	"B04E26": "TP-Link",
+	"B0A737": "Roku",
+	"B46BFC": "Intel",
+	"B47C9C": "Amazon",
+	"B4B52F": "Hewlett Packard",
+	"B4FBE4": "Ubiquiti",
+	"B827EB": "Raspberry Pi",
+	"B8AC6F": "Dell",
+	"B8E856": "Apple",
+	"B8E937": "Sonos",
+	"BC4486": "Samsung",
+	"BC52B7": "Apple",
+	"BCDDC2": "Espressif",
+	"C04A00": "TP-Link",
+	"C0BDD1": "Samsung",
+	"C0EEFB": "OnePlus",
+	"C40415": "Netgear",
+	"C44F33": "Espressif",
+	"C808E9": "LG",
+	"C82A14": "Apple",
+	"CC50E3": "Espressif",
+	"CC6DA0": "Roku",
+	"CCF735": "Amazon",
+	"D021F9": "Ubiquiti",
+	"D023DB": "Apple",
+	"D4BED9": "Dell",
+	"D83062": "Apple",
+	"D83134": "Roku",
+	"D83ADD": "Raspberry Pi",
+	"D86C63": "Google",
+	"D8BFC0": "Espressif",
+	"DC3A5E": "Roku",
+	"DC5360": "Intel",
+	"DC9FDB": "Ubiquiti",
+	"DCA632": "Raspberry Pi",
+	"DCA904": "Apple",
+	"E063DA": "Ubiquiti",
+	"E0F847": "Apple",
+	"E43883": "Ubiquiti",
+	"E45F01": "Raspberry Pi",
+	"E84ECE": "Nintendo",
+	"E8508B": "Samsung",
+	"EC086B": "TP-Link",
+	"EC1A59": "Belkin",
+	"ECB5FA": "Philips Hue",
+	"ECFABC": "Espressif",
+	"F01898": "Apple",
+	"F025B7": "Samsung",
+	"F0272D": "Amazon",
+	"F08173": "Amazon",
+	"F09FC2": "Ubiquiti",
+	"F0B479": "Apple",
+	"F0F6C1": "Sonos",
+	"F40F24": "Apple",
+	"F46D04": "ASUSTek",
+	"F4F26D": "TP-Link",
+	"F4F5D8": "Google",
+	"F4F5E8": "Google",
+	"F8042E": "Samsung",
+	"F81654": "Intel",
+	"F81EDF": "Apple",
+	"F88FCA": "Google",
+	"F8A45F": "Xiaomi",
+	"F8BC12": "Dell",
+	"FC0FE6": "Sony",
+	"FC65DE": "Amazon",
+	"FCA183": "Amazon",
+	"FCECDA": "Ubiquiti",
+}
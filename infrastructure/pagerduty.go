@@ -0,0 +1,114 @@
+package infrastructure
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+const (
+	PagerDutyEventsURL    = "https://events.pagerduty.com/v2/enqueue"
+	PagerDutyEventTrigger = "trigger"
+	PagerDutySource       = "unifi-notifications"
+)
+
+type PagerDutyHandler struct {
+	Config     model.Receiver
+	HTTPClient http.Client
+	Templates  TemplateHandler
+	Logger     *logrus.Logger
+}
+
+func NewPagerDutyHandler(config model.Receiver, templateHandler TemplateHandler, logger *logrus.Logger) PagerDutyHandler {
+	return PagerDutyHandler{Config: config, HTTPClient: http.Client{Timeout: time.Second * 30}, Templates: templateHandler, Logger: logger}
+}
+
+func (h *PagerDutyHandler) NotifyAlarms(unifiSiteAlarms model.UnifiSiteAlarms) error {
+	for site, unifiAlarms := range unifiSiteAlarms {
+		h.Logger.WithField("site", site).Infof("number of pagerduty alarms %d", len(unifiAlarms.Alarms))
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			err := h.trigger(model.PagerDutyPayload{
+				Summary:       h.Templates.AlarmText(model.ReceiverTypePagerDuty, site, unifiAlarms, unifiAlarm, ""),
+				Source:        source(unifiAlarms.Controller, siteName(site, unifiAlarms.SiteDescription)),
+				Severity:      pagerDutySeverity(unifiAlarm.Severity),
+				Timestamp:     unifiAlarm.Datetime.Format(time.RFC3339),
+				Component:     unifiAlarm.Subsystem,
+				Group:         siteName(site, unifiAlarms.SiteDescription),
+				Class:         unifiAlarm.Key,
+				CustomDetails: unifiAlarm,
+			}, unifiAlarm.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *PagerDutyHandler) NotifyEvents(unifiSiteEvents model.UnifiSiteEvents) error {
+	for site, unifiEvents := range unifiSiteEvents {
+		h.Logger.WithField("site", site).Infof("number of pagerduty events %d", len(unifiEvents.Events))
+		for _, unifiEvent := range unifiEvents.Events {
+			err := h.trigger(model.PagerDutyPayload{
+				Summary:       h.Templates.EventText(model.ReceiverTypePagerDuty, site, unifiEvents, unifiEvent, ""),
+				Source:        source(unifiEvents.Controller, siteName(site, unifiEvents.SiteDescription)),
+				Severity:      pagerDutySeverity(unifiEvent.Severity),
+				Timestamp:     unifiEvent.Datetime.Format(time.RFC3339),
+				Component:     unifiEvent.Subsystem,
+				Group:         siteName(site, unifiEvents.SiteDescription),
+				Class:         unifiEvent.Key,
+				CustomDetails: unifiEvent,
+			}, unifiEvent.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *PagerDutyHandler) trigger(payload model.PagerDutyPayload, dedupKey string) error {
+	event := model.PagerDutyEvent{
+		RoutingKey:  h.Config.RoutingKey,
+		EventAction: PagerDutyEventTrigger,
+		DedupKey:    dedupKey,
+		Payload:     payload,
+	}
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := h.HTTPClient.Post(PagerDutyEventsURL, "application/json", bytes.NewBuffer(eventBytes))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("could not trigger pagerduty event, receiver=%s status=%s body=%s", h.Config.Name, resp.Status, body)
+	}
+	return nil
+}
+
+func source(controller string, site string) string {
+	if controller != "" {
+		return fmt.Sprintf("%s/%s", controller, site)
+	}
+	return site
+}
+
+// pagerDutySeverity maps a severity to the PagerDuty severities, which include
+// info, warning and critical.
+func pagerDutySeverity(severity model.Severity) string {
+	if severity == "" {
+		return string(model.SeverityInfo)
+	}
+	return string(severity)
+}
@@ -0,0 +1,329 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// maxFailedBatches is how many failed batches are kept per receiver, the
+// oldest are dropped first.
+const maxFailedBatches = 100
+
+type Notifier interface {
+	NotifyAlarms(unifiSiteAlarms model.UnifiSiteAlarms) error
+	NotifyEvents(unifiSiteEvents model.UnifiSiteEvents) error
+}
+
+type RouteHandler struct {
+	Config    model.RouteConfig
+	Throttle  ThrottleHandler
+	Logger    *logrus.Logger
+	route     route
+	receivers map[string]Notifier
+	failed    *failedStore
+}
+
+// failedStore holds, per receiver, the batches it failed to send so only those
+// receivers are retried.
+type failedStore struct {
+	sync.Mutex
+	alarms map[string][]model.UnifiSiteAlarms
+	events map[string][]model.UnifiSiteEvents
+}
+
+type route struct {
+	model.Route
+	matcher matcher
+	routes  []route
+}
+
+// NewRouteHandler builds the routing tree. The slack webhooks configured with
+// environment variables are available as the slack-alarms and slack-events
+// receivers, without a routes file alarms are sent to slack-alarms and events
+// to slack-events.
+func NewRouteHandler(config model.RouteConfig, notificationServices []string, slackConfig model.SlackConfig, throttleHandler ThrottleHandler, templateHandler TemplateHandler, logger *logrus.Logger) (RouteHandler, error) {
+	h := RouteHandler{Config: config, Throttle: throttleHandler, Logger: logger, receivers: map[string]Notifier{}, failed: &failedStore{
+		alarms: map[string][]model.UnifiSiteAlarms{},
+		events: map[string][]model.UnifiSiteEvents{},
+	}}
+
+	routes := model.Routes{}
+	for _, notificationService := range notificationServices {
+		if notificationService == model.ReceiverTypeSlack {
+			routes.Receivers = append(routes.Receivers,
+				model.Receiver{Name: model.ReceiverSlackAlarms, Type: model.ReceiverTypeSlack, Webhook: slackConfig.AlarmsWebhook},
+				model.Receiver{Name: model.ReceiverSlackEvents, Type: model.ReceiverTypeSlack, Webhook: slackConfig.EventsWebhook},
+			)
+			routes.Route.Routes = []model.Route{
+				{Receiver: model.ReceiverSlackAlarms, Match: model.Matcher{Types: []string{SubjectTypeAlarm}}},
+				{Receiver: model.ReceiverSlackEvents, Match: model.Matcher{Types: []string{SubjectTypeEvent}}},
+			}
+		}
+	}
+
+	if config.File != "" {
+		routesBytes, err := ioutil.ReadFile(config.File)
+		if err != nil {
+			return RouteHandler{}, err
+		}
+		fileRoutes := model.Routes{}
+		err = json.Unmarshal(routesBytes, &fileRoutes)
+		if err != nil {
+			return RouteHandler{}, fmt.Errorf("could not parse routes file %s, error=%s", config.File, err)
+		}
+		routes.Receivers = append(routes.Receivers, fileRoutes.Receivers...)
+		routes.Route = fileRoutes.Route
+	}
+
+	for _, receiver := range routes.Receivers {
+		if _, ok := h.receivers[receiver.Name]; ok || receiver.Name == "" {
+			return RouteHandler{}, fmt.Errorf("receiver name %q must be set and unique", receiver.Name)
+		}
+		notifier, err := newNotifier(receiver, templateHandler, logger)
+		if err != nil {
+			return RouteHandler{}, err
+		}
+		h.receivers[receiver.Name] = notifier
+	}
+
+	var err error
+	h.route, err = h.newRoute(routes.Route, "")
+	if err != nil {
+		return RouteHandler{}, err
+	}
+	return h, nil
+}
+
+func newNotifier(receiver model.Receiver, templateHandler TemplateHandler, logger *logrus.Logger) (Notifier, error) {
+	switch receiver.Type {
+	case model.ReceiverTypeSlack:
+		if receiver.Webhook == "" {
+			return nil, fmt.Errorf("receiver %s: webhook is required", receiver.Name)
+		}
+		slackHandler := NewSlackHandler(model.SlackConfig{AlarmsWebhook: receiver.Webhook, EventsWebhook: receiver.Webhook}, templateHandler, logger)
+		return &slackHandler, nil
+	case model.ReceiverTypePagerDuty:
+		if receiver.RoutingKey == "" {
+			return nil, fmt.Errorf("receiver %s: routing_key is required", receiver.Name)
+		}
+		pagerDutyHandler := NewPagerDutyHandler(receiver, templateHandler, logger)
+		return &pagerDutyHandler, nil
+	case model.ReceiverTypeFile:
+		if receiver.Path == "" {
+			return nil, fmt.Errorf("receiver %s: path is required", receiver.Name)
+		}
+		fileHandler := NewFileHandler(receiver, templateHandler, logger)
+		return &fileHandler, nil
+	}
+	return nil, fmt.Errorf("receiver %s: invalid type %q, must be %s, %s or %s", receiver.Name, receiver.Type, model.ReceiverTypeSlack, model.ReceiverTypePagerDuty, model.ReceiverTypeFile)
+}
+
+func (h *RouteHandler) newRoute(config model.Route, parentReceiver string) (route, error) {
+	if config.Receiver == "" {
+		config.Receiver = parentReceiver
+	}
+	if _, ok := h.receivers[config.Receiver]; !ok && config.Receiver != "" {
+		return route{}, fmt.Errorf("route receiver %q is not defined", config.Receiver)
+	}
+
+	m, err := newMatcher(config.Match)
+	if err != nil {
+		return route{}, fmt.Errorf("route to %s: %s", config.Receiver, err)
+	}
+
+	r := route{Route: config, matcher: m}
+	for _, child := range config.Routes {
+		childRoute, err := h.newRoute(child, config.Receiver)
+		if err != nil {
+			return route{}, err
+		}
+		r.routes = append(r.routes, childRoute)
+	}
+	return r, nil
+}
+
+// receivers walks the routing tree and returns the receivers a subject is
+// sent to.
+func (r route) receivers(s subject) []string {
+	receivers := []string{}
+	for _, child := range r.routes {
+		if !child.matcher.match(s) {
+			continue
+		}
+		for _, receiver := range child.receivers(s) {
+			if !containsString(receivers, receiver) {
+				receivers = append(receivers, receiver)
+			}
+		}
+		if !child.Continue {
+			return receivers
+		}
+	}
+	if len(receivers) == 0 && r.Receiver != "" {
+		receivers = append(receivers, r.Receiver)
+	}
+	return receivers
+}
+
+func (h *RouteHandler) NotifyAlarms(unifiSiteAlarms model.UnifiSiteAlarms) error {
+	now := time.Now()
+	routed := map[string]model.UnifiSiteAlarms{}
+	for site, unifiAlarms := range unifiSiteAlarms {
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			receivers := h.route.receivers(alarmSubject(unifiAlarms.Controller, site, unifiAlarms.SiteDescription, unifiAlarm))
+			h.Logger.WithField("site", site).Debugf("routing alarm %s to %s", unifiAlarm.ID, strings.Join(receivers, ", "))
+			for _, receiver := range receivers {
+				if !h.Throttle.Allow(receiver, unifiAlarms.Controller, site, unifiAlarms.SiteDescription, unifiAlarm.Key, unifiAlarm.Severity, now) {
+					h.Logger.WithField("site", site).Debugf("throttled alarm %s to %s", unifiAlarm.ID, receiver)
+					continue
+				}
+				if _, ok := routed[receiver]; !ok {
+					routed[receiver] = model.UnifiSiteAlarms{}
+				}
+				receiverAlarms, ok := routed[receiver][site]
+				if !ok {
+					receiverAlarms = unifiAlarms
+					receiverAlarms.Alarms = nil
+				}
+				receiverAlarms.Alarms = append(receiverAlarms.Alarms, unifiAlarm)
+				routed[receiver][site] = receiverAlarms
+			}
+		}
+	}
+
+	var errs []string
+	for receiver, receiverAlarms := range routed {
+		err := h.receivers[receiver].NotifyAlarms(receiverAlarms)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("receiver %s: %s", receiver, err))
+			h.keepAlarms(receiver, receiverAlarms)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func (h *RouteHandler) NotifyEvents(unifiSiteEvents model.UnifiSiteEvents) error {
+	now := time.Now()
+	routed := map[string]model.UnifiSiteEvents{}
+	for site, unifiEvents := range unifiSiteEvents {
+		for _, unifiEvent := range unifiEvents.Events {
+			receivers := h.route.receivers(eventSubject(unifiEvents.Controller, site, unifiEvents.SiteDescription, unifiEvent))
+			h.Logger.WithField("site", site).Debugf("routing event %s to %s", unifiEvent.ID, strings.Join(receivers, ", "))
+			for _, receiver := range receivers {
+				if !h.Throttle.Allow(receiver, unifiEvents.Controller, site, unifiEvents.SiteDescription, unifiEvent.Key, unifiEvent.Severity, now) {
+					h.Logger.WithField("site", site).Debugf("throttled event %s to %s", unifiEvent.ID, receiver)
+					continue
+				}
+				if _, ok := routed[receiver]; !ok {
+					routed[receiver] = model.UnifiSiteEvents{}
+				}
+				receiverEvents, ok := routed[receiver][site]
+				if !ok {
+					receiverEvents = unifiEvents
+					receiverEvents.Events = nil
+				}
+				receiverEvents.Events = append(receiverEvents.Events, unifiEvent)
+				routed[receiver][site] = receiverEvents
+			}
+		}
+	}
+
+	var errs []string
+	for receiver, receiverEvents := range routed {
+		err := h.receivers[receiver].NotifyEvents(receiverEvents)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("receiver %s: %s", receiver, err))
+			h.keepEvents(receiver, receiverEvents)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// NotifyReceiver sends events to one receiver without routing or throttling
+// them, as the summaries of its throttled notifications are.
+func (h *RouteHandler) NotifyReceiver(receiver string, unifiSiteEvents model.UnifiSiteEvents) error {
+	err := h.receivers[receiver].NotifyEvents(unifiSiteEvents)
+	if err != nil {
+		h.keepEvents(receiver, unifiSiteEvents)
+		return fmt.Errorf("receiver %s: %s", receiver, err)
+	}
+	return nil
+}
+
+// RetryFailed sends the batches each receiver failed to send again, oldest
+// first. The retries bypass the throttle, they were counted when first sent. A
+// receiver is retried until its first failure, that batch and the ones after
+// it are kept for the next retry.
+func (h *RouteHandler) RetryFailed() error {
+	h.failed.Lock()
+	failedAlarms, failedEvents := h.failed.alarms, h.failed.events
+	h.failed.alarms, h.failed.events = map[string][]model.UnifiSiteAlarms{}, map[string][]model.UnifiSiteEvents{}
+	h.failed.Unlock()
+
+	var errs []string
+	for receiver, batches := range failedAlarms {
+		for i, receiverAlarms := range batches {
+			err := h.receivers[receiver].NotifyAlarms(receiverAlarms)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("receiver %s: %s", receiver, err))
+				for _, keep := range batches[i:] {
+					h.keepAlarms(receiver, keep)
+				}
+				break
+			}
+		}
+	}
+	for receiver, batches := range failedEvents {
+		for i, receiverEvents := range batches {
+			err := h.receivers[receiver].NotifyEvents(receiverEvents)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("receiver %s: %s", receiver, err))
+				for _, keep := range batches[i:] {
+					h.keepEvents(receiver, keep)
+				}
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func (h *RouteHandler) keepAlarms(receiver string, receiverAlarms model.UnifiSiteAlarms) {
+	h.failed.Lock()
+	defer h.failed.Unlock()
+	batches := append(h.failed.alarms[receiver], receiverAlarms)
+	if len(batches) > maxFailedBatches {
+		h.Logger.Warnf("dropping %d failed alarm batches to %s", len(batches)-maxFailedBatches, receiver)
+		batches = batches[len(batches)-maxFailedBatches:]
+	}
+	h.failed.alarms[receiver] = batches
+}
+
+func (h *RouteHandler) keepEvents(receiver string, receiverEvents model.UnifiSiteEvents) {
+	h.failed.Lock()
+	defer h.failed.Unlock()
+	batches := append(h.failed.events[receiver], receiverEvents)
+	if len(batches) > maxFailedBatches {
+		h.Logger.Warnf("dropping %d failed event batches to %s", len(batches)-maxFailedBatches, receiver)
+		batches = batches[len(batches)-maxFailedBatches:]
+	}
+	h.failed.events[receiver] = batches
+}
@@ -0,0 +1,385 @@
+package infrastructure
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// scheduleDigestTop is how many top clients and APs a queued digest lists.
+const scheduleDigestTop = 5
+
+type ScheduleHandler struct {
+	Config    model.ScheduleConfig
+	State     StateHandler
+	Logger    *logrus.Logger
+	schedules []schedule
+	windows   *scheduleStore
+}
+
+type schedule struct {
+	model.Schedule
+	matcher matcher
+	windows []timeWindow
+}
+
+type maintenanceWindow struct {
+	model.MaintenanceWindow
+	matcher matcher
+}
+
+type scheduleStore struct {
+	sync.Mutex
+	maintenance map[string]maintenanceWindow
+	queues      map[string]*scheduleQueue
+}
+
+// scheduleQueue holds the alarms and events of one site queued by a schedule
+// or maintenance window.
+type scheduleQueue struct {
+	schedule    string
+	maintenance string
+	digest      *siteDigest
+}
+
+// NewScheduleHandler loads the schedules file and the maintenance windows
+// saved by a previous run that have not ended yet.
+func NewScheduleHandler(config model.ScheduleConfig, stateHandler StateHandler, logger *logrus.Logger) (ScheduleHandler, error) {
+	h := ScheduleHandler{
+		Config:  config,
+		State:   stateHandler,
+		Logger:  logger,
+		windows: &scheduleStore{maintenance: map[string]maintenanceWindow{}, queues: map[string]*scheduleQueue{}},
+	}
+	for _, window := range stateHandler.Maintenance(time.Now()) {
+		m, err := newMaintenanceMatcher(window)
+		if err != nil {
+			return ScheduleHandler{}, fmt.Errorf("maintenance window %s: %s", window.ID, err)
+		}
+		h.windows.maintenance[window.ID] = maintenanceWindow{MaintenanceWindow: window, matcher: m}
+		logger.WithField("site", window.Site).Infof("restored maintenance window %s until %s", window.ID, window.End.Format(time.RFC3339))
+	}
+	if config.File == "" {
+		return h, nil
+	}
+
+	schedulesBytes, err := ioutil.ReadFile(config.File)
+	if err != nil {
+		return ScheduleHandler{}, err
+	}
+
+	schedules := []model.Schedule{}
+	err = json.Unmarshal(schedulesBytes, &schedules)
+	if err != nil {
+		return ScheduleHandler{}, fmt.Errorf("could not parse schedules file %s, error=%s", config.File, err)
+	}
+
+	names := map[string]bool{}
+	for i, config := range schedules {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("schedule %d", i+1)
+		}
+		if names[config.Name] {
+			return ScheduleHandler{}, fmt.Errorf("schedule name %q must be unique", config.Name)
+		}
+		names[config.Name] = true
+		if err := validateScheduleAction(config.Action); err != nil {
+			return ScheduleHandler{}, fmt.Errorf("schedule %s: %s", config.Name, err)
+		}
+		if len(config.Windows) == 0 {
+			return ScheduleHandler{}, fmt.Errorf("schedule %s: at least one window is required", config.Name)
+		}
+
+		m, err := newMatcher(config.Matcher)
+		if err != nil {
+			return ScheduleHandler{}, fmt.Errorf("schedule %s: %s", config.Name, err)
+		}
+		s := schedule{Schedule: config, matcher: m}
+		for _, window := range config.Windows {
+			w, err := newTimeWindow(window)
+			if err != nil {
+				return ScheduleHandler{}, fmt.Errorf("schedule %s: %s", config.Name, err)
+			}
+			s.windows = append(s.windows, w)
+		}
+		h.schedules = append(h.schedules, s)
+	}
+	logger.Infof("loaded %d schedules from %s", len(h.schedules), config.File)
+	return h, nil
+}
+
+func validateScheduleAction(action string) error {
+	if action != model.ScheduleActionSuppress && action != model.ScheduleActionDowngrade && action != model.ScheduleActionQueue {
+		return fmt.Errorf("invalid action %q, must be %s, %s or %s", action, model.ScheduleActionSuppress, model.ScheduleActionDowngrade, model.ScheduleActionQueue)
+	}
+	return nil
+}
+
+// AddMaintenance validates a maintenance window and starts applying it,
+// defaulting the action to suppress and the start to now.
+func (h *ScheduleHandler) AddMaintenance(window model.MaintenanceWindow) (model.MaintenanceWindow, error) {
+	if window.Action == "" {
+		window.Action = model.ScheduleActionSuppress
+	}
+	if err := validateScheduleAction(window.Action); err != nil {
+		return model.MaintenanceWindow{}, err
+	}
+	if window.Start.IsZero() {
+		window.Start = time.Now()
+	}
+	if !window.End.After(window.Start) {
+		return model.MaintenanceWindow{}, fmt.Errorf("end must be after start")
+	}
+
+	m, err := newMaintenanceMatcher(window)
+	if err != nil {
+		return model.MaintenanceWindow{}, err
+	}
+
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		return model.MaintenanceWindow{}, err
+	}
+	window.ID = hex.EncodeToString(id)
+
+	h.windows.Lock()
+	defer h.windows.Unlock()
+	h.windows.maintenance[window.ID] = maintenanceWindow{MaintenanceWindow: window, matcher: m}
+	h.State.SaveMaintenance(h.maintenance())
+	h.Logger.WithField("site", window.Site).Infof("added maintenance window %s from %s to %s", window.ID, window.Start.Format(time.RFC3339), window.End.Format(time.RFC3339))
+	return window, nil
+}
+
+func newMaintenanceMatcher(window model.MaintenanceWindow) (matcher, error) {
+	config := model.Matcher{MACs: window.Devices}
+	if window.Controller != "" {
+		config.Controllers = []string{window.Controller}
+	}
+	if window.Site != "" {
+		config.Sites = []string{window.Site}
+	}
+	return newMatcher(config)
+}
+
+// Maintenance returns the maintenance windows that have not ended, ordered by
+// start.
+func (h *ScheduleHandler) Maintenance() []model.MaintenanceWindow {
+	h.windows.Lock()
+	defer h.windows.Unlock()
+	return h.maintenance()
+}
+
+// maintenance returns the maintenance windows ordered by start, the caller
+// must hold the windows lock.
+func (h *ScheduleHandler) maintenance() []model.MaintenanceWindow {
+	windows := []model.MaintenanceWindow{}
+	for _, window := range h.windows.maintenance {
+		windows = append(windows, window.MaintenanceWindow)
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Start.Before(windows[j].Start)
+	})
+	return windows
+}
+
+// RemoveMaintenance ends a maintenance window early, anything it queued is
+// sent with the next flush.
+func (h *ScheduleHandler) RemoveMaintenance(id string) bool {
+	h.windows.Lock()
+	defer h.windows.Unlock()
+	if _, ok := h.windows.maintenance[id]; !ok {
+		return false
+	}
+	delete(h.windows.maintenance, id)
+	h.State.SaveMaintenance(h.maintenance())
+	h.Logger.Infof("removed maintenance window %s", id)
+	return true
+}
+
+// ScheduleAlarms suppresses, downgrades or queues the alarms matching an
+// active maintenance window or schedule, maintenance windows take precedence
+// and then the first matching schedule decides.
+func (h *ScheduleHandler) ScheduleAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	h.windows.Lock()
+	defer h.windows.Unlock()
+	now := time.Now()
+	scheduledUnifiSiteAlarms := model.UnifiSiteAlarms{}
+	for site, unifiAlarms := range unifiSiteAlarms {
+		scheduledUnifiAlarms := unifiAlarms
+		scheduledUnifiAlarms.Alarms = nil
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			s := alarmSubject(unifiAlarms.Controller, site, unifiAlarms.SiteDescription, unifiAlarm)
+			switch h.action(s, unifiAlarm.ID, now) {
+			case model.ScheduleActionSuppress:
+			case model.ScheduleActionQueue:
+				h.queue(s, now).digest.addAlarm(unifiAlarm, unifiAlarms.UnifiInventory)
+			case model.ScheduleActionDowngrade:
+				unifiAlarm.Severity = downgradeSeverity(unifiAlarm.Severity)
+				scheduledUnifiAlarms.Alarms = append(scheduledUnifiAlarms.Alarms, unifiAlarm)
+			default:
+				scheduledUnifiAlarms.Alarms = append(scheduledUnifiAlarms.Alarms, unifiAlarm)
+			}
+		}
+		scheduledUnifiSiteAlarms[site] = scheduledUnifiAlarms
+	}
+	return scheduledUnifiSiteAlarms
+}
+
+// ScheduleEvents suppresses, downgrades or queues the events matching an
+// active maintenance window or schedule, maintenance windows take precedence
+// and then the first matching schedule decides.
+func (h *ScheduleHandler) ScheduleEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	h.windows.Lock()
+	defer h.windows.Unlock()
+	now := time.Now()
+	scheduledUnifiSiteEvents := model.UnifiSiteEvents{}
+	for site, unifiEvents := range unifiSiteEvents {
+		scheduledUnifiEvents := unifiEvents
+		scheduledUnifiEvents.Events = nil
+		for _, unifiEvent := range unifiEvents.Events {
+			s := eventSubject(unifiEvents.Controller, site, unifiEvents.SiteDescription, unifiEvent)
+			switch h.action(s, unifiEvent.ID, now) {
+			case model.ScheduleActionSuppress:
+			case model.ScheduleActionQueue:
+				h.queue(s, now).digest.addEvent(unifiEvent, unifiEvents.UnifiInventory)
+			case model.ScheduleActionDowngrade:
+				unifiEvent.Severity = downgradeSeverity(unifiEvent.Severity)
+				scheduledUnifiEvents.Events = append(scheduledUnifiEvents.Events, unifiEvent)
+			default:
+				scheduledUnifiEvents.Events = append(scheduledUnifiEvents.Events, unifiEvent)
+			}
+		}
+		scheduledUnifiSiteEvents[site] = scheduledUnifiEvents
+	}
+	return scheduledUnifiSiteEvents
+}
+
+// Flush returns a digest of everything queued by a schedule or maintenance
+// window that is no longer active, or of everything queued when force is set.
+// Maintenance windows that have ended are removed.
+func (h *ScheduleHandler) Flush(now time.Time, force bool) []model.UnifiSiteEvents {
+	h.windows.Lock()
+	defer h.windows.Unlock()
+	ended := false
+	for id, window := range h.windows.maintenance {
+		if !now.Before(window.End) {
+			h.Logger.WithField("site", window.Site).Infof("maintenance window %s ended", id)
+			delete(h.windows.maintenance, id)
+			ended = true
+		}
+	}
+	if ended {
+		h.State.SaveMaintenance(h.maintenance())
+	}
+
+	summaries := []model.UnifiSiteEvents{}
+	for id, queue := range h.windows.queues {
+		name := fmt.Sprintf("schedule %s", queue.schedule)
+		active := h.scheduleActive(queue.schedule, now)
+		if queue.maintenance != "" {
+			name = fmt.Sprintf("maintenance window %s", queue.maintenance)
+			_, active = h.windows.maintenance[queue.maintenance]
+		}
+		if active && !force {
+			continue
+		}
+
+		h.Logger.WithField("site", queue.digest.site).Infof("sending %d alarms and events queued by %s", queue.digest.total, name)
+		summary := queue.digest.summary(now, scheduleDigestTop)
+		for site, unifiEvents := range summary {
+			for i := range unifiEvents.Events {
+				unifiEvents.Events[i].Msg = fmt.Sprintf("Queued during %s\n%s", name, unifiEvents.Events[i].Msg)
+			}
+			summary[site] = unifiEvents
+		}
+		summaries = append(summaries, summary)
+		delete(h.windows.queues, id)
+	}
+	return summaries
+}
+
+// action returns the action of the maintenance window or schedule applying to
+// a subject, the caller must hold the windows lock.
+func (h *ScheduleHandler) action(s subject, id string, now time.Time) string {
+	if window := h.maintenanceWindow(s, now); window != nil {
+		h.Logger.WithFields(logrus.Fields{"site": s.Site, "type": s.Type}).Debugf("maintenance window %s matched %s %s, action=%s", window.ID, s.Key, id, window.Action)
+		return window.Action
+	}
+	if schedule := h.schedule(s, now); schedule != nil {
+		h.Logger.WithFields(logrus.Fields{"site": s.Site, "type": s.Type}).Debugf("schedule %s matched %s %s, action=%s", schedule.Name, s.Key, id, schedule.Action)
+		return schedule.Action
+	}
+	return ""
+}
+
+// queue returns the queue of the window applying to a subject, the caller
+// must hold the windows lock.
+func (h *ScheduleHandler) queue(s subject, now time.Time) *scheduleQueue {
+	q := &scheduleQueue{}
+	if window := h.maintenanceWindow(s, now); window != nil {
+		q.maintenance = window.ID
+	} else if schedule := h.schedule(s, now); schedule != nil {
+		q.schedule = schedule.Name
+	}
+
+	id := fmt.Sprintf("%s/%s/%s/%s", q.maintenance, q.schedule, s.Controller, s.Site)
+	queue, ok := h.windows.queues[id]
+	if !ok {
+		q.digest = newSiteDigest(s.Controller, s.Site, s.SiteDesc, now)
+		queue = q
+		h.windows.queues[id] = queue
+	}
+	return queue
+}
+
+func (h *ScheduleHandler) maintenanceWindow(s subject, now time.Time) *model.MaintenanceWindow {
+	ids := []string{}
+	for id := range h.windows.maintenance {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		window := h.windows.maintenance[id]
+		if !now.Before(window.Start) && now.Before(window.End) && window.matcher.match(s) {
+			return &window.MaintenanceWindow
+		}
+	}
+	return nil
+}
+
+func (h *ScheduleHandler) schedule(s subject, now time.Time) *schedule {
+	for i := range h.schedules {
+		if h.schedules[i].active(now) && h.schedules[i].matcher.match(s) {
+			return &h.schedules[i]
+		}
+	}
+	return nil
+}
+
+func (h *ScheduleHandler) scheduleActive(name string, now time.Time) bool {
+	for _, s := range h.schedules {
+		if s.Name == name {
+			return s.active(now)
+		}
+	}
+	return false
+}
+
+func (s schedule) active(now time.Time) bool {
+	for _, w := range s.windows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
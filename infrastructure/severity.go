@@ -0,0 +1,132 @@
+package infrastructure
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+var severityRanks = map[model.Severity]int{
+	model.SeverityInfo:     0,
+	model.SeverityWarning:  1,
+	model.SeverityCritical: 2,
+}
+
+// keySeverities are the default severities of well known keys, the first
+// matching pattern wins.
+var keySeverities = []severityOverride{
+	{pattern: "EVT_*_Lost_Contact", severity: model.SeverityCritical},
+	{pattern: "EVT_GW_WANTransition", severity: model.SeverityCritical},
+	{pattern: "EVT_AD_LoginFailed", severity: model.SeverityWarning},
+	{pattern: "EVT_AP_DetectRogueAP", severity: model.SeverityWarning},
+	{pattern: "EVT_AP_Isolated", severity: model.SeverityWarning},
+	{pattern: "EVT_*_RestartedUnknown", severity: model.SeverityWarning},
+	{pattern: model.EventKeyDeviceOffline, severity: model.SeverityCritical},
+	{pattern: model.EventKeyDeviceFlapping, severity: model.SeverityWarning},
+	{pattern: model.EventKeyWANFailover, severity: model.SeverityWarning},
+	{pattern: model.EventKeyUpgradeFailed, severity: model.SeverityWarning},
+	{pattern: model.EventKeyHealthDegraded, severity: model.SeverityWarning},
+	{pattern: model.EventKeyNewClient, severity: model.SeverityWarning},
+	{pattern: model.EventKeyFloodDetected, severity: model.SeverityWarning},
+	{pattern: "EVT_WU_*", severity: model.SeverityInfo},
+	{pattern: "EVT_WG_*", severity: model.SeverityInfo},
+	{pattern: "EVT_LU_*", severity: model.SeverityInfo},
+	{pattern: "EVT_LG_*", severity: model.SeverityInfo},
+	{pattern: "EVT_AD_Login", severity: model.SeverityInfo},
+	{pattern: "EVT_*_Connected", severity: model.SeverityInfo},
+	{pattern: "EVT_*_Upgraded", severity: model.SeverityInfo},
+	{pattern: "EVT_*_Restarted", severity: model.SeverityInfo},
+	{pattern: "EVT_UN_*", severity: model.SeverityInfo},
+}
+
+type SeverityHandler struct {
+	Config    model.SeverityConfig
+	Logger    *logrus.Logger
+	overrides []severityOverride
+}
+
+type severityOverride struct {
+	pattern  string
+	severity model.Severity
+}
+
+func NewSeverityHandler(config model.SeverityConfig, logger *logrus.Logger) (SeverityHandler, error) {
+	h := SeverityHandler{Config: config, Logger: logger}
+	for _, override := range config.Overrides {
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 {
+			return SeverityHandler{}, fmt.Errorf("invalid severity override %q, must be key=severity", override)
+		}
+		pattern, severity := strings.TrimSpace(parts[0]), model.Severity(strings.ToLower(strings.TrimSpace(parts[1])))
+		if _, err := path.Match(pattern, ""); err != nil {
+			return SeverityHandler{}, fmt.Errorf("invalid severity override pattern %q, error=%s", pattern, err)
+		}
+		if _, ok := severityRanks[severity]; !ok {
+			return SeverityHandler{}, fmt.Errorf("invalid severity %q, must be %s, %s or %s", severity, model.SeverityInfo, model.SeverityWarning, model.SeverityCritical)
+		}
+		h.overrides = append(h.overrides, severityOverride{pattern: pattern, severity: severity})
+	}
+	return h, nil
+}
+
+// ClassifyAlarms sets the severity of the alarms that do not have one yet.
+func (h *SeverityHandler) ClassifyAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	for site, unifiAlarms := range unifiSiteAlarms {
+		for i, unifiAlarm := range unifiAlarms.Alarms {
+			if unifiAlarm.Severity == "" {
+				unifiAlarms.Alarms[i].Severity = h.severity(unifiAlarm.Key, unifiAlarm.InnerAlertSeverity, model.SeverityWarning)
+			}
+		}
+		unifiSiteAlarms[site] = unifiAlarms
+	}
+	return unifiSiteAlarms
+}
+
+// ClassifyEvents sets the severity of the events that do not have one yet.
+func (h *SeverityHandler) ClassifyEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	for site, unifiEvents := range unifiSiteEvents {
+		for i, unifiEvent := range unifiEvents.Events {
+			if unifiEvent.Severity == "" {
+				unifiEvents.Events[i].Severity = h.severity(unifiEvent.Key, unifiEvent.InnerAlertSeverity, model.SeverityInfo)
+			}
+		}
+		unifiSiteEvents[site] = unifiEvents
+	}
+	return unifiSiteEvents
+}
+
+// severity uses the first matching override, then the IPS severity, then the
+// default of the key and finally the fallback.
+func (h *SeverityHandler) severity(key string, innerAlertSeverity int64, fallback model.Severity) model.Severity {
+	for _, override := range h.overrides {
+		if ok, _ := path.Match(override.pattern, key); ok {
+			return override.severity
+		}
+	}
+	switch {
+	case innerAlertSeverity == 1:
+		return model.SeverityCritical
+	case innerAlertSeverity == 2:
+		return model.SeverityWarning
+	case innerAlertSeverity > 2:
+		return model.SeverityInfo
+	}
+	for _, keySeverity := range keySeverities {
+		if ok, _ := path.Match(keySeverity.pattern, key); ok {
+			return keySeverity.severity
+		}
+	}
+	return fallback
+}
+
+// downgradeSeverity lowers a severity one level.
+func downgradeSeverity(severity model.Severity) model.Severity {
+	if severity == model.SeverityCritical {
+		return model.SeverityWarning
+	}
+	return model.SeverityInfo
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 
@@ -15,12 +16,13 @@ import (
 const attachmentLimit = 20
 
 type SlackHandler struct {
-	Config model.SlackConfig
-	Logger *logrus.Logger
+	Config    model.SlackConfig
+	Templates TemplateHandler
+	Logger    *logrus.Logger
 }
 
-func NewSlackHandler(config model.SlackConfig, logger *logrus.Logger) SlackHandler {
-	return SlackHandler{Config: config, Logger: logger}
+func NewSlackHandler(config model.SlackConfig, templateHandler TemplateHandler, logger *logrus.Logger) SlackHandler {
+	return SlackHandler{Config: config, Templates: templateHandler, Logger: logger}
 }
 
 func (h *SlackHandler) NotifyAlarms(unifiSiteAlarms model.UnifiSiteAlarms) error {
@@ -31,10 +33,10 @@ func (h *SlackHandler) NotifyAlarms(unifiSiteAlarms model.UnifiSiteAlarms) error
 		h.Logger.WithField("site", site).Infof("number of alarms %d", len(unifiAlarms.Alarms))
 		for _, unifiAlarm := range unifiAlarms.Alarms {
 			attachments = append(attachments, slack.Attachment{
-				Color:  "danger",
-				Text:   fmt.Sprintf("<!channel> %s", unifiAlarm.Msg),
+				Color:  severityColor(unifiAlarm.Severity),
+				Text:   h.Templates.AlarmText(model.ReceiverTypeSlack, site, unifiAlarms, unifiAlarm, slackMention(unifiAlarm.Mention)),
 				Ts:     json.Number(strconv.FormatInt(unifiAlarm.Datetime.Unix(), 10)),
-				Fields: []slack.AttachmentField{{Title: "Site", Value: site, Short: true}},
+				Fields: attachmentFields(site, unifiAlarms.SiteDescription, unifiAlarms.Controller),
 			})
 
 			if len(attachments) >= attachmentLimit {
@@ -66,10 +68,10 @@ func (h *SlackHandler) NotifyEvents(unifiSiteEvents model.UnifiSiteEvents) error
 		h.Logger.WithField("site", site).Infof("number of events %d", len(unifiEvents.Events))
 		for _, unifiEvent := range unifiEvents.Events {
 			attachments = append(attachments, slack.Attachment{
-				Color:  "danger",
-				Text:   fmt.Sprintf("<!channel> %s %s", unifiEvent.Host, unifiEvent.Msg),
+				Color:  severityColor(unifiEvent.Severity),
+				Text:   h.Templates.EventText(model.ReceiverTypeSlack, site, unifiEvents, unifiEvent, slackMention(unifiEvent.Mention)),
 				Ts:     json.Number(strconv.FormatInt(unifiEvent.Datetime.Unix(), 10)),
-				Fields: []slack.AttachmentField{{Title: "Site", Value: site, Short: true}},
+				Fields: attachmentFields(site, unifiEvents.SiteDescription, unifiEvents.Controller),
 			})
 
 			if len(attachments) >= attachmentLimit {
@@ -92,3 +94,50 @@ func (h *SlackHandler) NotifyEvents(unifiSiteEvents model.UnifiSiteEvents) error
 	}
 	return nil
 }
+
+func attachmentFields(site string, description string, controller string) []slack.AttachmentField {
+	fields := []slack.AttachmentField{}
+	if site != "" {
+		fields = append(fields, slack.AttachmentField{Title: "Site", Value: siteName(site, description), Short: true})
+	}
+	if controller != "" {
+		fields = append(fields, slack.AttachmentField{Title: "Controller", Value: controller, Short: true})
+	}
+	return fields
+}
+
+func siteName(site string, description string) string {
+	if description != "" {
+		return description
+	}
+	return site
+}
+
+// severityColor is the Slack attachment colour of a severity.
+func severityColor(severity model.Severity) string {
+	switch severity {
+	case model.SeverityCritical:
+		return "danger"
+	case model.SeverityWarning:
+		return "warning"
+	}
+	return "good"
+}
+
+// slackMention formats a mention as a Slack message prefix.
+func slackMention(mention model.Mention) string {
+	mentions := []string{}
+	if mention.Broadcast != "" {
+		mentions = append(mentions, fmt.Sprintf("<!%s>", mention.Broadcast))
+	}
+	for _, user := range mention.Users {
+		mentions = append(mentions, fmt.Sprintf("<@%s>", user))
+	}
+	for _, group := range mention.Groups {
+		mentions = append(mentions, fmt.Sprintf("<!subteam^%s>", group))
+	}
+	if len(mentions) == 0 {
+		return ""
+	}
+	return strings.Join(mentions, " ") + " "
+}
@@ -0,0 +1,281 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+type StateHandler struct {
+	Config  model.StateConfig
+	Logger  *logrus.Logger
+	started time.Time
+	state   *stateStore
+}
+
+type stateStore struct {
+	sync.Mutex
+	model.State
+}
+
+// NewStateHandler loads the checkpoints saved by a previous run so alarms and
+// events that happened while the process was down are still notified. Without
+// a state file the checkpoints are only kept in memory.
+func NewStateHandler(config model.StateConfig, logger *logrus.Logger) (StateHandler, error) {
+	h := StateHandler{
+		Config:  config,
+		Logger:  logger,
+		started: time.Now(),
+		state:   &stateStore{State: model.State{Controllers: map[string]model.ControllerState{}}},
+	}
+	if config.File == "" {
+		return h, nil
+	}
+
+	stateBytes, err := ioutil.ReadFile(config.File)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return StateHandler{}, err
+	}
+
+	err = json.Unmarshal(stateBytes, &h.state.State)
+	if err != nil {
+		return StateHandler{}, err
+	}
+	if h.state.Controllers == nil {
+		h.state.Controllers = map[string]model.ControllerState{}
+	}
+	logger.Infof("loaded state from %s", config.File)
+	return h, nil
+}
+
+// AlarmsSince returns the datetime of the newest alarm notified for a site,
+// less the dedup overlap to allow for clock skew, and no further back than the
+// max catch up window.
+func (h *StateHandler) AlarmsSince(controller string) func(site string) time.Time {
+	return func(site string) time.Time {
+		h.state.Lock()
+		defer h.state.Unlock()
+		return h.since(h.siteState(controller, site).AlarmsChecked)
+	}
+}
+
+// EventsSince returns the datetime of the newest event notified for a site,
+// less the dedup overlap to allow for clock skew, and no further back than the
+// max catch up window.
+func (h *StateHandler) EventsSince(controller string) func(site string) time.Time {
+	return func(site string) time.Time {
+		h.state.Lock()
+		defer h.state.Unlock()
+		return h.since(h.siteState(controller, site).EventsChecked)
+	}
+}
+
+// FilterAlarms drops alarms that were already notified or are repeated.
+func (h *StateHandler) FilterAlarms(unifiSiteAlarms model.UnifiSiteAlarms) model.UnifiSiteAlarms {
+	h.state.Lock()
+	defer h.state.Unlock()
+	filteredUnifiSiteAlarms := model.UnifiSiteAlarms{}
+	for site, unifiAlarms := range unifiSiteAlarms {
+		siteState := h.siteState(unifiAlarms.Controller, site)
+		filteredUnifiAlarms := unifiAlarms
+		filteredUnifiAlarms.Alarms = nil
+		batch := map[string]bool{}
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			if _, ok := siteState.AlarmIDs[unifiAlarm.ID]; ok || batch[unifiAlarm.ID] {
+				h.Logger.WithField("site", site).Debugf("skipping already notified alarm %s", unifiAlarm.ID)
+				continue
+			}
+			batch[unifiAlarm.ID] = true
+			filteredUnifiAlarms.Alarms = append(filteredUnifiAlarms.Alarms, unifiAlarm)
+		}
+		filteredUnifiSiteAlarms[site] = filteredUnifiAlarms
+	}
+	return filteredUnifiSiteAlarms
+}
+
+// FilterEvents drops events that were already notified or are repeated.
+func (h *StateHandler) FilterEvents(unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
+	h.state.Lock()
+	defer h.state.Unlock()
+	filteredUnifiSiteEvents := model.UnifiSiteEvents{}
+	for site, unifiEvents := range unifiSiteEvents {
+		siteState := h.siteState(unifiEvents.Controller, site)
+		filteredUnifiEvents := unifiEvents
+		filteredUnifiEvents.Events = nil
+		batch := map[string]bool{}
+		for _, unifiEvent := range unifiEvents.Events {
+			if _, ok := siteState.EventIDs[unifiEvent.ID]; ok || batch[unifiEvent.ID] {
+				h.Logger.WithField("site", site).Debugf("skipping already notified event %s", unifiEvent.ID)
+				continue
+			}
+			batch[unifiEvent.ID] = true
+			filteredUnifiEvents.Events = append(filteredUnifiEvents.Events, unifiEvent)
+		}
+		filteredUnifiSiteEvents[site] = filteredUnifiEvents
+	}
+	return filteredUnifiSiteEvents
+}
+
+// CheckpointAlarms records the alarms as notified and moves each site's
+// checkpoint to its newest alarm, then saves the state file.
+func (h *StateHandler) CheckpointAlarms(unifiSiteAlarms model.UnifiSiteAlarms) {
+	h.state.Lock()
+	defer h.state.Unlock()
+	now := time.Now()
+	for site, unifiAlarms := range unifiSiteAlarms {
+		siteState := h.siteState(unifiAlarms.Controller, site)
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			siteState.AlarmIDs[unifiAlarm.ID] = unifiAlarm.Datetime
+			if unifiAlarm.Datetime.After(siteState.AlarmsChecked) {
+				siteState.AlarmsChecked = unifiAlarm.Datetime
+			}
+		}
+		pruneIDs(siteState.AlarmIDs, now, h.Config.DedupTTL, h.Config.DedupMaxIDs)
+	}
+	h.save()
+}
+
+// CheckpointEvents records the events as notified and moves each site's
+// checkpoint to its newest event, then saves the state file.
+func (h *StateHandler) CheckpointEvents(unifiSiteEvents model.UnifiSiteEvents) {
+	h.state.Lock()
+	defer h.state.Unlock()
+	now := time.Now()
+	for site, unifiEvents := range unifiSiteEvents {
+		siteState := h.siteState(unifiEvents.Controller, site)
+		for _, unifiEvent := range unifiEvents.Events {
+			siteState.EventIDs[unifiEvent.ID] = unifiEvent.Datetime
+			if unifiEvent.Datetime.After(siteState.EventsChecked) {
+				siteState.EventsChecked = unifiEvent.Datetime
+			}
+		}
+		pruneIDs(siteState.EventIDs, now, h.Config.DedupTTL, h.Config.DedupMaxIDs)
+	}
+	h.save()
+}
+
+// Maintenance returns the saved maintenance windows that have not ended.
+func (h *StateHandler) Maintenance(now time.Time) []model.MaintenanceWindow {
+	h.state.Lock()
+	defer h.state.Unlock()
+	windows := []model.MaintenanceWindow{}
+	for _, window := range h.state.Maintenance {
+		if now.Before(window.End) {
+			windows = append(windows, window)
+		}
+	}
+	return windows
+}
+
+// SaveMaintenance replaces the saved maintenance windows, then saves the state
+// file.
+func (h *StateHandler) SaveMaintenance(windows []model.MaintenanceWindow) {
+	h.state.Lock()
+	defer h.state.Unlock()
+	h.state.Maintenance = windows
+	h.save()
+}
+
+// KnownClients returns the known clients, keyed to when they last connected,
+// and since when clients are tracked. Tracking starts with the first run and
+// is kept across restarts.
+func (h *StateHandler) KnownClients() (map[string]time.Time, time.Time) {
+	h.state.Lock()
+	defer h.state.Unlock()
+	if h.state.ClientsSince.IsZero() {
+		h.state.ClientsSince = h.started
+		h.save()
+	}
+	clients := map[string]time.Time{}
+	for id, connected := range h.state.Clients {
+		clients[id] = connected
+	}
+	return clients, h.state.ClientsSince
+}
+
+// SaveClients replaces the known clients, then saves the state file.
+func (h *StateHandler) SaveClients(clients map[string]time.Time) {
+	h.state.Lock()
+	defer h.state.Unlock()
+	h.state.Clients = clients
+	h.save()
+}
+
+func (h *StateHandler) since(checked time.Time) time.Time {
+	if checked.IsZero() {
+		return h.started
+	}
+	since := checked.Add(-h.Config.DedupOverlap)
+	earliest := time.Now().Add(-h.Config.MaxCatchUp)
+	if since.Before(earliest) {
+		return earliest
+	}
+	return since
+}
+
+func (h *StateHandler) siteState(controller string, site string) *model.SiteState {
+	controllerState, ok := h.state.Controllers[controller]
+	if !ok {
+		controllerState = model.ControllerState{}
+		h.state.Controllers[controller] = controllerState
+	}
+	siteState, ok := controllerState[site]
+	if !ok || siteState == nil {
+		siteState = &model.SiteState{}
+		controllerState[site] = siteState
+	}
+	if siteState.AlarmIDs == nil {
+		siteState.AlarmIDs = map[string]time.Time{}
+	}
+	if siteState.EventIDs == nil {
+		siteState.EventIDs = map[string]time.Time{}
+	}
+	return siteState
+}
+
+// save writes the state to a temporary file and renames it over the state
+// file so a crash never leaves a partially written file behind. The caller
+// must hold the state lock.
+func (h *StateHandler) save() {
+	if h.Config.File == "" {
+		return
+	}
+
+	stateBytes, err := json.Marshal(h.state.State)
+	if err != nil {
+		h.Logger.Errorf("could not encode state, error=%s", err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(h.Config.File), filepath.Base(h.Config.File))
+	if err != nil {
+		h.Logger.Errorf("could not save state, error=%s", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(stateBytes)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), h.Config.File)
+	}
+	if err != nil {
+		h.Logger.Errorf("could not save state, error=%s", err)
+	}
+}
@@ -0,0 +1,230 @@
+package infrastructure
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+const templateExt = ".tmpl"
+
+// defaultTemplates are used unless the templates directory has a template of
+// the same name. Templates are named <key>, <type>, <notifier>/<key> or
+// <notifier>/<type> where type is alarm or event.
+var defaultTemplates = map[string]string{
+	SubjectTypeAlarm: `{{.Msg}}`,
+	SubjectTypeEvent: `{{.Msg}}`,
+	model.ReceiverTypeSlack + "/" + SubjectTypeAlarm: `{{.Mention}}{{.Msg}}`,
+	model.ReceiverTypeSlack + "/" + SubjectTypeEvent: `{{.Mention}}{{with .Event.Host}}{{.}} {{end}}{{.Msg}}`,
+	model.ReceiverTypeSlack + "/EVT_WU_Disconnected": `{{.Mention}}{{with .Event.Host}}{{.}} {{end}}{{.Msg}} after {{duration .Event.Duration}}, {{bytes .Event.Bytes}} transferred`,
+	model.ReceiverTypeSlack + "/EVT_WG_Disconnected": `{{.Mention}}{{with .Event.Host}}{{.}} {{end}}{{.Msg}} after {{duration .Event.Duration}}, {{bytes .Event.Bytes}} transferred`,
+}
+
+var templateFuncs = template.FuncMap{
+	"time":     formatTime,
+	"ago":      ago,
+	"duration": humanDuration,
+	"bytes":    humanBytes,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"join":     strings.Join,
+}
+
+type TemplateHandler struct {
+	Config    model.TemplateConfig
+	Logger    *logrus.Logger
+	templates map[string]*template.Template
+}
+
+// templateData is what templates are executed with, Alarm or Event is set
+// depending on Type.
+type templateData struct {
+	Type            string
+	Notifier        string
+	Controller      string
+	Site            string
+	SiteDescription string
+	Key             string
+	Msg             string
+	Severity        model.Severity
+	Mention         string
+	Datetime        time.Time
+	Alarm           model.UnifiAlarm
+	Event           model.UnifiEvent
+	names           map[string]string
+	hosts           map[string]string
+}
+
+// NewTemplateHandler parses the built-in templates and then every .tmpl file
+// in the templates directory, a file replaces the built-in template with the
+// same name, e.g. slack/EVT_AP_Lost_Contact.tmpl.
+func NewTemplateHandler(config model.TemplateConfig, logger *logrus.Logger) (TemplateHandler, error) {
+	h := TemplateHandler{Config: config, Logger: logger, templates: map[string]*template.Template{}}
+	for name, text := range defaultTemplates {
+		t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return TemplateHandler{}, fmt.Errorf("built-in template %s: %s", name, err)
+		}
+		h.templates[name] = t
+	}
+	if config.Dir == "" {
+		return h, nil
+	}
+
+	count := 0
+	err := filepath.Walk(config.Dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(file) != templateExt {
+			return err
+		}
+		rel, err := filepath.Rel(config.Dir, file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), templateExt)
+
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		t, err := template.New(name).Funcs(templateFuncs).Parse(strings.TrimRight(string(text), "\n"))
+		if err != nil {
+			return fmt.Errorf("template %s: %s", file, err)
+		}
+		h.templates[name] = t
+		count++
+		return nil
+	})
+	if err != nil {
+		return TemplateHandler{}, err
+	}
+	logger.Infof("loaded %d templates from %s", count, config.Dir)
+	return h, nil
+}
+
+// AlarmText renders the text of an alarm for a notifier.
+func (h *TemplateHandler) AlarmText(notifier string, site string, unifiAlarms model.UnifiAlarms, unifiAlarm model.UnifiAlarm, mention string) string {
+	return h.render(templateData{
+		Type:            SubjectTypeAlarm,
+		Notifier:        notifier,
+		Controller:      unifiAlarms.Controller,
+		Site:            site,
+		SiteDescription: unifiAlarms.SiteDescription,
+		Key:             unifiAlarm.Key,
+		Msg:             unifiAlarm.Msg,
+		Severity:        unifiAlarm.Severity,
+		Mention:         mention,
+		Datetime:        unifiAlarm.Datetime,
+		Alarm:           unifiAlarm,
+		names:           unifiAlarms.Names,
+		hosts:           unifiAlarms.Hosts,
+	})
+}
+
+// EventText renders the text of an event for a notifier.
+func (h *TemplateHandler) EventText(notifier string, site string, unifiEvents model.UnifiEvents, unifiEvent model.UnifiEvent, mention string) string {
+	return h.render(templateData{
+		Type:            SubjectTypeEvent,
+		Notifier:        notifier,
+		Controller:      unifiEvents.Controller,
+		Site:            site,
+		SiteDescription: unifiEvents.SiteDescription,
+		Key:             unifiEvent.Key,
+		Msg:             unifiEvent.Msg,
+		Severity:        unifiEvent.Severity,
+		Mention:         mention,
+		Datetime:        unifiEvent.Datetime,
+		Event:           unifiEvent,
+		names:           unifiEvents.Names,
+		hosts:           unifiEvents.Hosts,
+	})
+}
+
+// render uses the most specific template, from the notifier's template for
+// the key down to the generic template for the type. The message is used as
+// is when the template fails.
+func (h *TemplateHandler) render(data templateData) string {
+	for _, name := range []string{
+		data.Notifier + "/" + data.Key,
+		data.Notifier + "/" + data.Type,
+		data.Key,
+		data.Type,
+	} {
+		t, ok := h.templates[name]
+		if !ok {
+			continue
+		}
+		var text bytes.Buffer
+		err := t.Execute(&text, data)
+		if err != nil {
+			h.Logger.WithField("site", data.Site).Errorf("could not render template %s, error=%s", name, err)
+			break
+		}
+		return text.String()
+	}
+	return data.Msg
+}
+
+// Name returns the name of the device or client with a MAC, or the MAC when it
+// is not known.
+func (d templateData) Name(mac string) string {
+	if name, ok := d.names[strings.ToLower(mac)]; ok {
+		return name
+	}
+	return mac
+}
+
+// Hostname returns the name of the client or the reverse DNS name of an IP, or
+// the IP when it has no name.
+func (d templateData) Hostname(ip string) string {
+	if name, ok := d.hosts[ip]; ok {
+		return name
+	}
+	return ip
+}
+
+func formatTime(layout string, t time.Time) string {
+	return t.Local().Format(layout)
+}
+
+func ago(t time.Time) string {
+	return humanDuration(time.Since(t))
+}
+
+// humanDuration formats a time.Duration, or a number of seconds as used by
+// the controller, rounded to the second.
+func humanDuration(v interface{}) string {
+	var d time.Duration
+	switch value := v.(type) {
+	case time.Duration:
+		d = value
+	case int64:
+		d = time.Duration(value) * time.Second
+	case int:
+		d = time.Duration(value) * time.Second
+	default:
+		return fmt.Sprint(v)
+	}
+	return (d / time.Second * time.Second).String()
+}
+
+func humanBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,209 @@
+package infrastructure
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+const breakerWindow = time.Minute
+
+type ThrottleHandler struct {
+	Config   model.ThrottleConfig
+	Logger   *logrus.Logger
+	throttle *throttleStore
+}
+
+type throttleStore struct {
+	sync.Mutex
+	buckets    map[string]*tokenBucket
+	suppressed map[string]*suppressedCount
+	breaker    circuitBreaker
+}
+
+// tokenBucket refills at rate tokens per second up to burst tokens.
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+// suppressedCount is how many notifications of one key were dropped for a
+// receiver and site since the last flush, severity is the highest of them.
+type suppressedCount struct {
+	receiver   string
+	controller string
+	site       string
+	siteDesc   string
+	key        string
+	severity   model.Severity
+	count      int
+	first      time.Time
+}
+
+// circuitBreaker counts notifications in fixed one minute windows.
+type circuitBreaker struct {
+	windowStart time.Time
+	count       int
+	tripped     bool
+	calmSince   time.Time
+}
+
+func NewThrottleHandler(config model.ThrottleConfig, logger *logrus.Logger) ThrottleHandler {
+	return ThrottleHandler{
+		Config: config,
+		Logger: logger,
+		throttle: &throttleStore{
+			buckets:    map[string]*tokenBucket{},
+			suppressed: map[string]*suppressedCount{},
+		},
+	}
+}
+
+// Allow takes a token from the receiver's bucket and the bucket of the key for
+// that receiver. When either is empty the notification is counted as
+// suppressed and false is returned.
+func (h *ThrottleHandler) Allow(receiver string, controller string, site string, siteDesc string, key string, severity model.Severity, now time.Time) bool {
+	if !h.Config.Enabled {
+		return true
+	}
+
+	h.throttle.Lock()
+	defer h.throttle.Unlock()
+	receiverBucket := h.bucket(receiver, h.Config.ReceiverRate, h.Config.ReceiverBurst)
+	keyBucket := h.bucket(fmt.Sprintf("%s/%s", receiver, key), h.Config.KeyRate, h.Config.KeyBurst)
+	receiverBucket.refill(now)
+	keyBucket.refill(now)
+	if receiverBucket.tokens >= 1 && keyBucket.tokens >= 1 {
+		receiverBucket.tokens--
+		keyBucket.tokens--
+		return true
+	}
+
+	id := fmt.Sprintf("%s/%s/%s/%s", receiver, controller, site, key)
+	suppressed, ok := h.throttle.suppressed[id]
+	if !ok {
+		suppressed = &suppressedCount{receiver: receiver, controller: controller, site: site, siteDesc: siteDesc, key: key, first: now}
+		h.throttle.suppressed[id] = suppressed
+	}
+	if !atLeast(suppressed.severity, severity) {
+		suppressed.severity = severity
+	}
+	suppressed.count++
+	return false
+}
+
+// Suppressed returns, per receiver, an event saying how many notifications
+// were suppressed for each site and key since the last call. The event has the
+// highest severity of the suppressed notifications.
+func (h *ThrottleHandler) Suppressed(now time.Time) map[string][]model.UnifiSiteEvents {
+	h.throttle.Lock()
+	defer h.throttle.Unlock()
+	ids := []string{}
+	for id := range h.throttle.suppressed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	summaries := map[string][]model.UnifiSiteEvents{}
+	for _, id := range ids {
+		s := h.throttle.suppressed[id]
+		h.Logger.WithField("site", s.site).Warnf("suppressed %d %s notifications to %s", s.count, s.key, s.receiver)
+		unifiEvent := syntheticEvent(fmt.Sprintf("suppressed-%s-%d", id, now.Unix()), model.EventKeySuppressed, now,
+			fmt.Sprintf("%d more %s notifications suppressed since %s", s.count, s.key, s.first.Format(digestTimeFormat)))
+		unifiEvent.Severity = s.severity
+		summaries[s.receiver] = append(summaries[s.receiver], model.UnifiSiteEvents{s.site: model.UnifiEvents{
+			Events:          []model.UnifiEvent{unifiEvent},
+			SiteDescription: s.siteDesc,
+			Controller:      s.controller,
+		}})
+		delete(h.throttle.suppressed, id)
+	}
+	return summaries
+}
+
+// Observe counts notifications for the circuit breaker. It trips when more
+// than the threshold arrive within a minute and resets once every minute of
+// the recovery period stayed at or below it. A notice is returned when the
+// breaker changes state.
+func (h *ThrottleHandler) Observe(count int, now time.Time) (bool, model.UnifiSiteEvents) {
+	if h.Config.BreakerThreshold <= 0 {
+		return false, nil
+	}
+
+	h.throttle.Lock()
+	defer h.throttle.Unlock()
+	b := &h.throttle.breaker
+	if b.windowStart.IsZero() {
+		b.windowStart = now
+	}
+	if now.Sub(b.windowStart) >= breakerWindow {
+		if b.count > h.Config.BreakerThreshold {
+			b.calmSince = time.Time{}
+		} else if b.calmSince.IsZero() {
+			b.calmSince = b.windowStart
+		}
+		b.windowStart = now
+		b.count = 0
+	}
+	b.count += count
+
+	if !b.tripped && b.count > h.Config.BreakerThreshold {
+		b.tripped = true
+		b.calmSince = time.Time{}
+		h.Logger.Warnf("flood protection engaged, %d alarms and events within a minute", b.count)
+		return true, h.notice(model.EventKeyFloodDetected, now, fmt.Sprintf("Flood protection engaged, more than %d alarms and events within a minute, sending digests until the rate drops", h.Config.BreakerThreshold))
+	}
+	if b.tripped && b.count > h.Config.BreakerThreshold {
+		b.calmSince = time.Time{}
+	}
+	if b.tripped && !b.calmSince.IsZero() && now.Sub(b.calmSince) >= h.Config.BreakerRecovery {
+		b.tripped = false
+		b.calmSince = time.Time{}
+		h.Logger.Info("flood protection disengaged")
+		return false, h.notice(model.EventKeyFloodRecovered, now, fmt.Sprintf("Flood protection disengaged, alarms and events stayed at or below %d a minute for %s", h.Config.BreakerThreshold, h.Config.BreakerRecovery))
+	}
+	return b.tripped, nil
+}
+
+func (h *ThrottleHandler) notice(key string, now time.Time, msg string) model.UnifiSiteEvents {
+	unifiEvent := syntheticEvent(fmt.Sprintf("%s-%d", key, now.Unix()), key, now, msg)
+	return model.UnifiSiteEvents{"": model.UnifiEvents{Events: []model.UnifiEvent{unifiEvent}}}
+}
+
+// bucket returns the bucket for an id, the caller must hold the throttle lock.
+func (h *ThrottleHandler) bucket(id string, perMinute float64, burst int) *tokenBucket {
+	bucket, ok := h.throttle.buckets[id]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), rate: perMinute / 60, burst: float64(burst)}
+		h.throttle.buckets[id] = bucket
+	}
+	return bucket
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+func syntheticEvent(id string, key string, now time.Time, msg string) model.UnifiEvent {
+	return model.UnifiEvent{
+		ID:        id,
+		Key:       key,
+		Subsystem: model.SyntheticSubsystem,
+		Time:      now.UnixNano() / int64(time.Millisecond),
+		Datetime:  now,
+		Msg:       msg,
+	}
+}
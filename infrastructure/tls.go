@@ -0,0 +1,106 @@
+package infrastructure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// NewTLSConfig builds the TLS configuration used to talk to the controller.
+// Certificates are verified against the system roots, or the CA bundle when one
+// is configured. Pins are matched against the SHA-256 of the certificate or its
+// public key, when pins are configured without a CA bundle they replace chain
+// verification so a self-signed controller certificate can be trusted.
+func NewTLSConfig(config model.UnifiConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+	if config.CAFile != "" {
+		caBytes, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificates found in ca file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.CertPins) == 0 {
+		return tlsConfig, nil
+	}
+
+	pins := [][]byte{}
+	for _, pin := range config.CertPins {
+		pinBytes, err := parsePin(pin)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pinBytes)
+	}
+
+	if config.CAFile == "" {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		certs := []*x509.Certificate{}
+		if tlsConfig.InsecureSkipVerify {
+			// an unverified chain only proves possession of the leaf key
+			if len(rawCerts) == 0 {
+				return errors.New("controller presented no certificates")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		} else {
+			for _, chain := range verifiedChains {
+				certs = append(certs, chain...)
+			}
+		}
+
+		for _, cert := range certs {
+			certSum := sha256.Sum256(cert.Raw)
+			spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(pin, certSum[:]) || bytes.Equal(pin, spkiSum[:]) {
+					return nil
+				}
+			}
+		}
+		return errors.New("controller certificate does not match any configured pin")
+	}
+	return tlsConfig, nil
+}
+
+// parsePin accepts a SHA-256 fingerprint as hex, optionally colon separated, or
+// base64 with an optional sha256/ prefix.
+func parsePin(pin string) ([]byte, error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	if pinBytes, err := hex.DecodeString(strings.Replace(pin, ":", "", -1)); err == nil && len(pinBytes) == sha256.Size {
+		return pinBytes, nil
+	}
+	if pinBytes, err := base64.StdEncoding.DecodeString(pin); err == nil && len(pinBytes) == sha256.Size {
+		return pinBytes, nil
+	}
+	return nil, fmt.Errorf("certificate pin %q is not a sha256 fingerprint", pin)
+}
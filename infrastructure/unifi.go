@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strings"
+	"path"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -17,129 +19,217 @@ import (
 
 const (
 	LoginURI           = "api/login"
+	SelfSitesURI       = "api/self/sites"
 	StatAlarmURI       = "api/s/%s/stat/alarm"
 	StatEventURI       = "api/s/%s/stat/event"
 	StatDeviceBasicURI = "api/s/%s/stat/device-basic"
+	StatDeviceURI      = "api/s/%s/stat/device"
+	StatHealthURI      = "api/s/%s/stat/health"
+	StatSysinfoURI     = "api/s/%s/stat/sysinfo"
 	ListUserURI        = "api/s/%s/list/user"
 	ContentType        = "application/json;charset=UTF-8"
 	AuthCookieName     = "unifises"
+	APIKeyHeader       = "X-API-KEY"
 	AuthCookieDuration = time.Minute * 19
 	PaginateBy         = 20
+	SiteRetryInterval  = time.Minute
 )
 
-var session model.UnifiSession
-
 type UnifiHandler struct {
 	Config     model.UnifiConfig
 	HTTPClient http.Client
 	Logger     *logrus.Logger
+	streaming  *sync.Map
+	sites      *siteCache
+	session    *unifiSession
+	inventory  *inventoryCache
 }
 
-func NewUnifiHandler(config model.UnifiConfig, httpClient http.Client, logger *logrus.Logger) UnifiHandler {
-	return UnifiHandler{Config: config, HTTPClient: httpClient, Logger: logger}
+type unifiSession struct {
+	sync.Mutex
+	model.UnifiSession
 }
 
-func (h *UnifiHandler) GetAlarms(since time.Time) (model.UnifiSiteAlarms, error) {
-	unifiSiteAlarms := make(model.UnifiSiteAlarms)
-	for _, site := range h.Config.Sites {
-		pagination := model.UnifiPagination{Limit: 0, Start: 0}
-		newUnifiAlarms := model.UnifiAlarms{}
+type siteCache struct {
+	sync.Mutex
+	names        []string
+	descriptions map[string]string
+	refreshed    time.Time
+}
 
-		for {
-			pagination.Start = pagination.Start + pagination.Limit
-			pagination.Limit = pagination.Limit + PaginateBy
+func NewUnifiHandler(config model.UnifiConfig, httpClient http.Client, logger *logrus.Logger) UnifiHandler {
+	return UnifiHandler{
+		Config:     config,
+		HTTPClient: httpClient,
+		Logger:     logger,
+		streaming:  &sync.Map{},
+		sites:      &siteCache{names: config.Sites, descriptions: map[string]string{}},
+		session:    &unifiSession{},
+		inventory:  newInventoryCache(),
+	}
+}
 
-			body, _, err := h.getURI(fmt.Sprintf(StatAlarmURI, site), pagination)
-			if err != nil {
-				return model.UnifiSiteAlarms{}, err
-			}
+// Sites returns the sites to check. With discovery enabled the list is
+// refreshed from the controller every discovery interval so new sites are
+// picked up without a restart.
+//
+// The sites are fetched without holding the lock, the other pollers keep
+// using the previous list until the refresh is done.
+func (h *UnifiHandler) Sites() []string {
+	h.sites.Lock()
+	stale := time.Since(h.sites.refreshed) > h.Config.SiteDiscoveryInterval
+	if stale {
+		// claim the refresh so concurrent callers do not refresh too
+		h.sites.refreshed = time.Now()
+	}
+	h.sites.Unlock()
 
-			unifiAlarms := model.UnifiAlarms{}
-			err = json.Unmarshal(body, &unifiAlarms)
-			if err != nil {
-				return model.UnifiSiteAlarms{}, err
-			}
+	if stale {
+		unifiSites, err := h.fetchSites()
+		h.sites.Lock()
+		if err != nil {
+			h.Logger.Errorf("could not refresh unifi sites, error=%s", err)
+			// retry sooner than the discovery interval
+			h.sites.refreshed = time.Now().Add(SiteRetryInterval - h.Config.SiteDiscoveryInterval)
+		} else {
+			h.refreshSites(unifiSites)
+		}
+		h.sites.Unlock()
+	}
 
-			var done bool
-			for _, unifiAlarm := range unifiAlarms.Alarms {
-				if unifiAlarm.Datetime.After(since) {
-					newUnifiAlarms.Alarms = append(newUnifiAlarms.Alarms, unifiAlarm)
-				} else {
-					done = true
-					break
-				}
-			}
+	h.sites.Lock()
+	defer h.sites.Unlock()
+	return append([]string{}, h.sites.names...)
+}
 
-			if done {
-				break
-			}
-		}
+func (h *UnifiHandler) SiteDescription(site string) string {
+	h.sites.Lock()
+	defer h.sites.Unlock()
+	if description, ok := h.sites.descriptions[site]; ok && description != "" {
+		return description
+	}
+	return site
+}
 
-		unifiSiteAlarms[site] = newUnifiAlarms
+func (h *UnifiHandler) GetAlarms(since func(site string) time.Time) (model.UnifiSiteAlarms, error) {
+	unifiSiteAlarms := make(model.UnifiSiteAlarms)
+	for _, site := range h.pollSites() {
+		unifiAlarms, err := h.getSiteAlarms(site, since(site))
+		if err != nil {
+			return model.UnifiSiteAlarms{}, err
+		}
+		unifiSiteAlarms[site] = unifiAlarms
 	}
 	return unifiSiteAlarms, nil
 }
 
-func (h *UnifiHandler) GetEvents(since time.Time) (model.UnifiSiteEvents, error) {
-	unifiSiteDevices, err := h.getDevices()
-	if err != nil {
-		return model.UnifiSiteEvents{}, err
-	}
+// getSiteAlarms returns the alarms of a site newer than since.
+func (h *UnifiHandler) getSiteAlarms(site string, since time.Time) (model.UnifiAlarms, error) {
+	pagination := model.UnifiPagination{Limit: 0, Start: 0}
+	newUnifiAlarms := model.UnifiAlarms{SiteDescription: h.SiteDescription(site), Controller: h.Config.Name}
+	for {
+		pagination.Start = pagination.Start + pagination.Limit
+		pagination.Limit = pagination.Limit + PaginateBy
 
-	unifiSiteUsers, err := h.getUsers()
-	if err != nil {
-		return model.UnifiSiteEvents{}, err
+		body, _, err := h.getURI(fmt.Sprintf(StatAlarmURI, site), pagination)
+		if err != nil {
+			return model.UnifiAlarms{}, err
+		}
+
+		unifiAlarms := model.UnifiAlarms{}
+		err = json.Unmarshal(body, &unifiAlarms)
+		if err != nil {
+			return model.UnifiAlarms{}, err
+		}
+
+		var done bool
+		for _, unifiAlarm := range unifiAlarms.Alarms {
+			if unifiAlarm.Datetime.After(since) {
+				newUnifiAlarms.Alarms = append(newUnifiAlarms.Alarms, unifiAlarm)
+			} else {
+				done = true
+				break
+			}
+		}
+
+		if done || len(unifiAlarms.Alarms) == 0 {
+			break
+		}
 	}
 
-	unifiSiteEvents := make(model.UnifiSiteEvents)
-	for _, site := range h.Config.Sites {
-		pagination := model.UnifiPagination{Limit: 0, Start: 0}
-		newUnifiEvents := model.UnifiEvents{}
+	newUnifiAlarms.UnifiInventory = h.Inventory(site)
+	return newUnifiAlarms, nil
+}
 
-		for {
-			pagination.Start = pagination.Start + pagination.Limit
-			pagination.Limit = pagination.Limit + PaginateBy
+func (h *UnifiHandler) GetEvents(since func(site string) time.Time) (model.UnifiSiteEvents, error) {
+	unifiSiteEvents := make(model.UnifiSiteEvents)
+	for _, site := range h.pollSites() {
+		unifiEvents, err := h.getSiteEvents(site, since(site))
+		if err != nil {
+			return model.UnifiSiteEvents{}, err
+		}
+		unifiSiteEvents[site] = unifiEvents
+	}
+	return unifiSiteEvents, nil
+}
 
-			body, _, err := h.getURI(fmt.Sprintf(StatEventURI, site), pagination)
-			if err != nil {
-				return model.UnifiSiteEvents{}, err
-			}
+// getSiteEvents returns the events of a site newer than since.
+func (h *UnifiHandler) getSiteEvents(site string, since time.Time) (model.UnifiEvents, error) {
+	pagination := model.UnifiPagination{Limit: 0, Start: 0}
+	newUnifiEvents := model.UnifiEvents{SiteDescription: h.SiteDescription(site), Controller: h.Config.Name}
+	for {
+		pagination.Start = pagination.Start + pagination.Limit
+		pagination.Limit = pagination.Limit + PaginateBy
 
-			unifiEvents := model.UnifiEvents{}
-			err = json.Unmarshal(body, &unifiEvents)
-			if err != nil {
-				return model.UnifiSiteEvents{}, err
-			}
+		body, _, err := h.getURI(fmt.Sprintf(StatEventURI, site), pagination)
+		if err != nil {
+			return model.UnifiEvents{}, err
+		}
 
-			var done bool
-			for _, unifiEvent := range unifiEvents.Events {
-				if unifiEvent.Datetime.After(since) {
-					unifiEvent.Msg = replaceDeviceAndUserMac(unifiSiteDevices[site], unifiSiteUsers[site], unifiEvent.Msg)
-					newUnifiEvents.Events = append(newUnifiEvents.Events, unifiEvent)
-				} else {
-					done = true
-					break
-				}
-			}
+		unifiEvents := model.UnifiEvents{}
+		err = json.Unmarshal(body, &unifiEvents)
+		if err != nil {
+			return model.UnifiEvents{}, err
+		}
 
-			if done {
+		var done bool
+		for _, unifiEvent := range unifiEvents.Events {
+			if unifiEvent.Datetime.After(since) {
+				newUnifiEvents.Events = append(newUnifiEvents.Events, unifiEvent)
+			} else {
+				done = true
 				break
 			}
 		}
 
-		unifiSiteEvents[site] = newUnifiEvents
+		if done || len(unifiEvents.Events) == 0 {
+			break
+		}
 	}
-	return unifiSiteEvents, nil
+
+	h.updateInventory(site, newUnifiEvents.Events)
+	newUnifiEvents.UnifiInventory = h.Inventory(site)
+	return newUnifiEvents, nil
+}
+
+func (h *UnifiHandler) authenticate(req *http.Request) error {
+	if h.Config.APIKey != "" {
+		req.Header.Set(APIKeyHeader, h.Config.APIKey)
+		return nil
+	}
+	return h.setAuthCookie(req.URL)
 }
 
 func (h *UnifiHandler) setAuthCookie(url *url.URL) error {
-	if session == (model.UnifiSession{}) || session.Expiration.After(time.Now()) {
+	h.session.Lock()
+	defer h.session.Unlock()
+	if h.session.UnifiSession == (model.UnifiSession{}) || h.session.Expiration.Before(time.Now()) {
 		err := h.login()
 		if err != nil {
 			return err
 		}
 	}
-	cookie := http.Cookie{Name: AuthCookieName, Value: session.Key}
+	cookie := http.Cookie{Name: AuthCookieName, Value: h.session.Key}
 	h.HTTPClient.Jar.SetCookies(url, []*http.Cookie{&cookie})
 	return nil
 }
@@ -167,7 +257,7 @@ func (h *UnifiHandler) login() error {
 	body := string(bodyBytes)
 	for _, cookie := range resp.Cookies() {
 		if cookie.Name == AuthCookieName && cookie.Value != "" {
-			session = model.UnifiSession{
+			h.session.UnifiSession = model.UnifiSession{
 				Key:        cookie.Value,
 				Expiration: time.Now().Add(AuthCookieDuration),
 			}
@@ -182,16 +272,25 @@ func (h *UnifiHandler) getURI(uri string, pagination model.UnifiPagination) ([]b
 	if err != nil {
 		return []byte{}, nil, err
 	}
+	return h.request(http.MethodPost, uri, bytes.NewBuffer(paginationBytes))
+}
+
+func (h *UnifiHandler) request(method string, uri string, reqBody io.Reader) ([]byte, *http.Response, error) {
 	u, err := url.Parse(fmt.Sprintf("%s/%s", h.Config.URL, uri))
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	h.Logger.Debugf("getting unifi url %s", u)
-	err = h.setAuthCookie(u)
+	req, err := http.NewRequest(method, u.String(), reqBody)
+	if err != nil {
+		return []byte{}, nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	err = h.authenticate(req)
 	if err != nil {
 		return []byte{}, nil, err
 	}
-	resp, err := h.HTTPClient.Post(u.String(), ContentType, bytes.NewBuffer(paginationBytes))
+	resp, err := h.HTTPClient.Do(req)
 	if err != nil {
 		return []byte{}, resp, err
 	}
@@ -200,13 +299,46 @@ func (h *UnifiHandler) getURI(uri string, pagination model.UnifiPagination) ([]b
 	return body, resp, err
 }
 
-func (h *UnifiHandler) getDevices() (model.UnifiSiteDevices, error) {
-	unifiSiteDevices := make(model.UnifiSiteDevices)
-	for _, site := range h.Config.Sites {
-		pagination := model.UnifiPagination{Limit: 0, Start: 0}
-		newUnifiDevices := model.UnifiDevices{}
+func (h *UnifiHandler) fetchSites() (model.UnifiSites, error) {
+	body, _, err := h.request(http.MethodGet, SelfSitesURI, nil)
+	if err != nil {
+		return model.UnifiSites{}, err
+	}
+
+	unifiSites := model.UnifiSites{}
+	err = json.Unmarshal(body, &unifiSites)
+	return unifiSites, err
+}
+
+// refreshSites swaps in the fetched sites, the caller holds the lock.
+func (h *UnifiHandler) refreshSites(unifiSites model.UnifiSites) {
+	names := []string{}
+	descriptions := map[string]string{}
+	for _, unifiSite := range unifiSites.Sites {
+		descriptions[unifiSite.Name] = unifiSite.Desc
+		if h.Config.SiteDiscovery && matchSite(unifiSite, h.Config.SitesInclude, h.Config.SitesExclude) {
+			names = append(names, unifiSite.Name)
+		}
+	}
+
+	if h.Config.SiteDiscovery {
+		for _, name := range names {
+			if !containsString(h.sites.names, name) {
+				h.Logger.WithField("site", name).Infof("discovered unifi site %s", descriptions[name])
+			}
+		}
+		h.sites.names = names
+	}
+	h.sites.descriptions = descriptions
+	h.sites.refreshed = time.Now()
+}
 
-		body, _, err := h.getURI(fmt.Sprintf(StatDeviceBasicURI, site), pagination)
+// GetDevices returns the full status of the devices of every site, streamed
+// or not.
+func (h *UnifiHandler) GetDevices() (model.UnifiSiteDevices, error) {
+	unifiSiteDevices := make(model.UnifiSiteDevices)
+	for _, site := range h.Sites() {
+		body, _, err := h.getURI(fmt.Sprintf(StatDeviceURI, site), model.UnifiPagination{})
 		if err != nil {
 			return model.UnifiSiteDevices{}, err
 		}
@@ -216,52 +348,136 @@ func (h *UnifiHandler) getDevices() (model.UnifiSiteDevices, error) {
 		if err != nil {
 			return model.UnifiSiteDevices{}, err
 		}
-
-		for _, unifiDevice := range unifiDevices.Devices {
-			newUnifiDevices.Devices = append(newUnifiDevices.Devices, unifiDevice)
-		}
-
-		unifiSiteDevices[site] = newUnifiDevices
+		unifiDevices.SiteDescription = h.SiteDescription(site)
+		unifiDevices.Controller = h.Config.Name
+		unifiSiteDevices[site] = unifiDevices
 	}
 	return unifiSiteDevices, nil
 }
 
-func (h *UnifiHandler) getUsers() (model.UnifiSiteUsers, error) {
-	unifiSiteUsers := make(model.UnifiSiteUsers)
-	for _, site := range h.Config.Sites {
-		pagination := model.UnifiPagination{Limit: 0, Start: 0}
-		newUnifiUsers := model.UnifiUsers{}
-
-		body, _, err := h.getURI(fmt.Sprintf(ListUserURI, site), pagination)
+// GetHealth returns the health of the subsystems of every site, streamed or
+// not.
+func (h *UnifiHandler) GetHealth() (model.UnifiSiteHealth, error) {
+	unifiSiteHealth := make(model.UnifiSiteHealth)
+	for _, site := range h.Sites() {
+		body, _, err := h.getURI(fmt.Sprintf(StatHealthURI, site), model.UnifiPagination{})
 		if err != nil {
-			return model.UnifiSiteUsers{}, err
+			return model.UnifiSiteHealth{}, err
 		}
 
-		unifiUsers := model.UnifiUsers{}
-		err = json.Unmarshal(body, &unifiUsers)
+		unifiHealth := model.UnifiHealth{}
+		err = json.Unmarshal(body, &unifiHealth)
 		if err != nil {
-			return model.UnifiSiteUsers{}, err
+			return model.UnifiSiteHealth{}, err
 		}
+		unifiHealth.SiteDescription = h.SiteDescription(site)
+		unifiHealth.Controller = h.Config.Name
+		unifiSiteHealth[site] = unifiHealth
+	}
+	return unifiSiteHealth, nil
+}
 
-		for _, unifiUser := range unifiUsers.Users {
-			newUnifiUsers.Users = append(newUnifiUsers.Users, unifiUser)
-		}
+// GetSysinfo returns the version of the controller and whether an update is
+// available, asking the first site as it is the same for all of them.
+func (h *UnifiHandler) GetSysinfo() (model.UnifiSysinfoData, error) {
+	sites := h.Sites()
+	if len(sites) == 0 {
+		return model.UnifiSysinfoData{}, nil
+	}
 
-		unifiSiteUsers[site] = newUnifiUsers
+	body, _, err := h.getURI(fmt.Sprintf(StatSysinfoURI, sites[0]), model.UnifiPagination{})
+	if err != nil {
+		return model.UnifiSysinfoData{}, err
+	}
+
+	unifiSysinfo := model.UnifiSysinfo{}
+	err = json.Unmarshal(body, &unifiSysinfo)
+	if err != nil {
+		return model.UnifiSysinfoData{}, err
 	}
-	return unifiSiteUsers, nil
+	if len(unifiSysinfo.Sysinfo) == 0 {
+		return model.UnifiSysinfoData{}, nil
+	}
+	return unifiSysinfo.Sysinfo[0], nil
 }
 
-func replaceDeviceAndUserMac(unifiDevices model.UnifiDevices, unifiUsers model.UnifiUsers, msg string) string {
+func (h *UnifiHandler) getSiteDevices(site string) (model.UnifiDevices, error) {
+	pagination := model.UnifiPagination{Limit: 0, Start: 0}
+	newUnifiDevices := model.UnifiDevices{}
+
+	body, _, err := h.getURI(fmt.Sprintf(StatDeviceBasicURI, site), pagination)
+	if err != nil {
+		return model.UnifiDevices{}, err
+	}
+
+	unifiDevices := model.UnifiDevices{}
+	err = json.Unmarshal(body, &unifiDevices)
+	if err != nil {
+		return model.UnifiDevices{}, err
+	}
+
 	for _, unifiDevice := range unifiDevices.Devices {
-		if unifiDevice.Mac != "" && unifiDevice.Name != "" {
-			msg = strings.ReplaceAll(msg, unifiDevice.Mac, unifiDevice.Name)
-		}
+		newUnifiDevices.Devices = append(newUnifiDevices.Devices, unifiDevice)
 	}
+	return newUnifiDevices, nil
+}
+
+func (h *UnifiHandler) getSiteUsers(site string) (model.UnifiUsers, error) {
+	pagination := model.UnifiPagination{Limit: 0, Start: 0}
+	newUnifiUsers := model.UnifiUsers{}
+
+	body, _, err := h.getURI(fmt.Sprintf(ListUserURI, site), pagination)
+	if err != nil {
+		return model.UnifiUsers{}, err
+	}
+
+	unifiUsers := model.UnifiUsers{}
+	err = json.Unmarshal(body, &unifiUsers)
+	if err != nil {
+		return model.UnifiUsers{}, err
+	}
+
 	for _, unifiUser := range unifiUsers.Users {
-		if unifiUser.Mac != "" && unifiUser.Hostname != "" {
-			msg = strings.ReplaceAll(msg, unifiUser.Mac, unifiUser.Hostname)
+		newUnifiUsers.Users = append(newUnifiUsers.Users, unifiUser)
+	}
+	return newUnifiUsers, nil
+}
+
+// pollSites returns the sites that are not currently being streamed over the
+// controller WebSocket.
+func (h *UnifiHandler) pollSites() []string {
+	sites := []string{}
+	for _, site := range h.Sites() {
+		if !h.Streaming(site) {
+			sites = append(sites, site)
+		}
+	}
+	return sites
+}
+
+func matchSite(unifiSite model.UnifiSite, include []string, exclude []string) bool {
+	if len(include) > 0 && !matchGlobs(include, unifiSite.Name, unifiSite.Desc) {
+		return false
+	}
+	return !matchGlobs(exclude, unifiSite.Name, unifiSite.Desc)
+}
+
+func matchGlobs(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if ok, _ := path.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
 	}
-	return msg
+	return false
 }
@@ -0,0 +1,215 @@
+package infrastructure
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+// inventoryCache holds the devices and clients of every site indexed by
+// lower case MAC. It is refreshed from the controller every inventory refresh
+// interval and kept up to date in between from the events.
+type inventoryCache struct {
+	sync.Mutex
+	sites map[string]*siteInventory
+}
+
+// siteInventory is locked on its own so a slow site does not hold up the
+// others. snapshot is rebuilt only when the inventory changes and is shared
+// by every caller, its maps must not be modified.
+type siteInventory struct {
+	sync.Mutex
+	devices   map[string]model.UnifiDevice
+	users     map[string]model.UnifiUser
+	refreshed time.Time
+	loaded    bool
+	snapshot  model.UnifiInventory
+}
+
+func newInventoryCache() *inventoryCache {
+	return &inventoryCache{sites: map[string]*siteInventory{}}
+}
+
+func (c *inventoryCache) site(site string) *siteInventory {
+	c.Lock()
+	defer c.Unlock()
+	inventory, ok := c.sites[site]
+	if !ok {
+		inventory = &siteInventory{devices: map[string]model.UnifiDevice{}, users: map[string]model.UnifiUser{}}
+		inventory.rebuild()
+		c.sites[site] = inventory
+	}
+	return inventory
+}
+
+// Inventory returns the names, vendors and IPs of the devices and clients of a
+// site, refreshing the site from the controller when it is older than the
+// inventory refresh interval. The controller is called without holding a
+// lock, callers meanwhile get the previous inventory. When the refresh fails
+// the previous inventory is used and the refresh is retried sooner.
+func (h *UnifiHandler) Inventory(site string) model.UnifiInventory {
+	inventory := h.inventory.site(site)
+	inventory.Lock()
+	stale := time.Since(inventory.refreshed) > h.Config.InventoryRefreshInterval
+	if stale {
+		// claim the refresh so concurrent callers do not refresh too
+		inventory.refreshed = time.Now()
+	}
+	snapshot := inventory.snapshot
+	inventory.Unlock()
+	if !stale {
+		return snapshot
+	}
+
+	unifiDevices, unifiUsers, err := h.fetchInventory(site)
+
+	inventory.Lock()
+	defer inventory.Unlock()
+	if err != nil {
+		h.Logger.WithField("site", site).Errorf("could not refresh unifi inventory, error=%s", err)
+		inventory.refreshed = time.Now().Add(SiteRetryInterval - h.Config.InventoryRefreshInterval)
+		return inventory.snapshot
+	}
+
+	inventory.devices = make(map[string]model.UnifiDevice, len(unifiDevices.Devices))
+	for _, unifiDevice := range unifiDevices.Devices {
+		if unifiDevice.Mac != "" {
+			inventory.devices[strings.ToLower(unifiDevice.Mac)] = unifiDevice
+		}
+	}
+	inventory.users = make(map[string]model.UnifiUser, len(unifiUsers.Users))
+	for _, unifiUser := range unifiUsers.Users {
+		if unifiUser.Mac != "" {
+			inventory.users[strings.ToLower(unifiUser.Mac)] = unifiUser
+		}
+	}
+	inventory.refreshed = time.Now()
+	inventory.loaded = true
+	inventory.rebuild()
+	h.Logger.WithField("site", site).Debugf("refreshed unifi inventory, devices=%d clients=%d", len(inventory.devices), len(inventory.users))
+	return inventory.snapshot
+}
+
+func (h *UnifiHandler) fetchInventory(site string) (model.UnifiDevices, model.UnifiUsers, error) {
+	unifiDevices, err := h.getSiteDevices(site)
+	if err != nil {
+		return model.UnifiDevices{}, model.UnifiUsers{}, err
+	}
+	unifiUsers, err := h.getSiteUsers(site)
+	if err != nil {
+		return model.UnifiDevices{}, model.UnifiUsers{}, err
+	}
+	return unifiDevices, unifiUsers, nil
+}
+
+// updateInventory adds the clients seen in events that are not in the
+// inventory yet, such as a new client connecting, and picks up renamed devices
+// without waiting for the next refresh. The snapshot is only rebuilt when
+// something changed.
+func (h *UnifiHandler) updateInventory(site string, unifiEvents []model.UnifiEvent) {
+	h.inventory.Lock()
+	inventory, ok := h.inventory.sites[site]
+	h.inventory.Unlock()
+	if !ok {
+		return
+	}
+
+	inventory.Lock()
+	defer inventory.Unlock()
+	var changed bool
+	// the controller returns the newest event first
+	for i := len(unifiEvents) - 1; i >= 0; i-- {
+		unifiEvent := unifiEvents[i]
+		if mac := strings.ToLower(unifiEvent.User); mac != "" {
+			if unifiUser, ok := inventory.users[mac]; !ok || (unifiUser.Hostname == "" && unifiEvent.Hostname != "") {
+				if !ok {
+					unifiUser.FirstSeen = unifiEvent.Datetime.Unix()
+				}
+				unifiUser.Mac = mac
+				unifiUser.Hostname = unifiEvent.Hostname
+				inventory.users[mac] = unifiUser
+				changed = true
+			}
+			if unifiUser := inventory.users[mac]; unifiEvent.IP != "" && unifiUser.IP != unifiEvent.IP {
+				unifiUser.IP = unifiEvent.IP
+				inventory.users[mac] = unifiUser
+				changed = true
+			}
+		}
+		for _, device := range [][2]string{{unifiEvent.Ap, unifiEvent.ApName}, {unifiEvent.Sw, unifiEvent.SwName}, {unifiEvent.Gw, unifiEvent.GwName}} {
+			mac, name := strings.ToLower(device[0]), device[1]
+			if mac == "" || name == "" || inventory.devices[mac].Name == name {
+				continue
+			}
+			unifiDevice := inventory.devices[mac]
+			unifiDevice.Mac = mac
+			unifiDevice.Name = name
+			inventory.devices[mac] = unifiDevice
+			changed = true
+		}
+	}
+	if changed {
+		inventory.rebuild()
+	}
+}
+
+// rebuild replaces the snapshot, the caller holds the lock. The previous
+// snapshot is left untouched for the callers still using it.
+func (i *siteInventory) rebuild() {
+	i.snapshot = model.UnifiInventory{Names: i.names(), Vendors: i.vendors(), Hosts: i.hosts(), FirstSeen: i.firstSeen()}
+}
+
+// names maps the MACs of devices and users to their names, the alias of a
+// user is preferred over its hostname and its note.
+func (i *siteInventory) names() map[string]string {
+	names := make(map[string]string, len(i.devices)+len(i.users))
+	for mac, unifiUser := range i.users {
+		if name := firstNonEmpty(unifiUser.Name, unifiUser.Hostname, unifiUser.Note); name != "" {
+			names[mac] = name
+		}
+	}
+	for mac, unifiDevice := range i.devices {
+		if unifiDevice.Name != "" {
+			names[mac] = unifiDevice.Name
+		}
+	}
+	return names
+}
+
+// vendors maps the MACs of users to the vendor the controller knows them by.
+func (i *siteInventory) vendors() map[string]string {
+	vendors := map[string]string{}
+	for mac, unifiUser := range i.users {
+		if unifiUser.Oui != "" {
+			vendors[mac] = unifiUser.Oui
+		}
+	}
+	return vendors
+}
+
+// hosts maps the IPs of users to their names.
+func (i *siteInventory) hosts() map[string]string {
+	hosts := map[string]string{}
+	for _, unifiUser := range i.users {
+		ip := firstNonEmpty(unifiUser.IP, unifiUser.LastIP)
+		if name := firstNonEmpty(unifiUser.Name, unifiUser.Hostname, unifiUser.Note); ip != "" && name != "" {
+			hosts[ip] = name
+		}
+	}
+	return hosts
+}
+
+// firstSeen maps the MACs of users to when the controller first saw them, it
+// is nil until the inventory was loaded from the controller.
+func (i *siteInventory) firstSeen() map[string]time.Time {
+	if !i.loaded {
+		return nil
+	}
+	firstSeen := make(map[string]time.Time, len(i.users))
+	for mac, unifiUser := range i.users {
+		firstSeen[mac] = time.Unix(unifiUser.FirstSeen, 0)
+	}
+	return firstSeen
+}
@@ -0,0 +1,232 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+const (
+	StreamURI              = "wss/s/%s/events"
+	StreamMessageEvents    = "events"
+	StreamMessageAlarm     = "alarm"
+	StreamMinBackoff       = time.Second
+	StreamHandshakeTimeout = time.Second * 30
+)
+
+// StreamSites keeps a Stream running for every site returned by Sites until
+// quit is closed, starting streams for newly discovered sites and stopping
+// streams for sites that went away.
+func (h *UnifiHandler) StreamSites(alarmsSince func(site string) time.Time, eventsSince func(site string) time.Time, siteAlarms chan<- model.UnifiSiteAlarms, siteEvents chan<- model.UnifiSiteEvents, quit <-chan struct{}) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	streams := map[string]chan struct{}{}
+	for {
+		sites := h.Sites()
+		for _, site := range sites {
+			if _, ok := streams[site]; ok {
+				continue
+			}
+			siteQuit := make(chan struct{})
+			streams[site] = siteQuit
+			wg.Add(1)
+			go func(site string) {
+				defer wg.Done()
+				h.Stream(site, alarmsSince, eventsSince, siteAlarms, siteEvents, siteQuit)
+			}(site)
+		}
+
+		for site, siteQuit := range streams {
+			if !containsString(sites, site) {
+				close(siteQuit)
+				delete(streams, site)
+			}
+		}
+
+		select {
+		case <-time.After(h.Config.SiteDiscoveryInterval):
+		case <-quit:
+			for _, siteQuit := range streams {
+				close(siteQuit)
+			}
+			return
+		}
+	}
+}
+
+// Stream receives alarms and events for a site from the controller WebSocket
+// until quit is closed. While the socket is connected the site is skipped by
+// GetAlarms and GetEvents, when it drops the site falls back to polling until
+// the reconnect succeeds. Every time the socket connects the site is polled
+// once from the checkpoint, so what happened while it was down is not lost.
+func (h *UnifiHandler) Stream(site string, alarmsSince func(site string) time.Time, eventsSince func(site string) time.Time, siteAlarms chan<- model.UnifiSiteAlarms, siteEvents chan<- model.UnifiSiteEvents, quit <-chan struct{}) {
+	logger := h.Logger.WithField("site", site)
+	backoff := StreamMinBackoff
+	for {
+		connected, err := h.stream(site, alarmsSince, eventsSince, siteAlarms, siteEvents, quit)
+		h.streaming.Delete(site)
+
+		select {
+		case <-quit:
+			return
+		default:
+		}
+
+		if connected {
+			backoff = StreamMinBackoff
+		}
+		logger.Warnf("unifi event stream unavailable, polling until reconnected in %s, error=%s", backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-quit:
+			return
+		}
+
+		backoff = backoff * 2
+		if backoff > h.Config.StreamMaxBackoff {
+			backoff = h.Config.StreamMaxBackoff
+		}
+	}
+}
+
+func (h *UnifiHandler) Streaming(site string) bool {
+	_, ok := h.streaming.Load(site)
+	return ok
+}
+
+func (h *UnifiHandler) stream(site string, alarmsSince func(site string) time.Time, eventsSince func(site string) time.Time, siteAlarms chan<- model.UnifiSiteAlarms, siteEvents chan<- model.UnifiSiteEvents, quit <-chan struct{}) (bool, error) {
+	u, err := url.Parse(fmt.Sprintf("%s/%s", h.Config.URL, fmt.Sprintf(StreamURI, site)))
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	err = h.authenticate(req)
+	if err != nil {
+		return false, err
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+
+	dialer := websocket.Dialer{Jar: h.HTTPClient.Jar, HandshakeTimeout: StreamHandshakeTimeout}
+	if tr, ok := h.HTTPClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = tr.TLSClientConfig
+	}
+
+	h.Logger.Debugf("connecting to unifi event stream at url %s", u)
+	conn, resp, err := dialer.Dial(u.String(), req.Header)
+	if err != nil {
+		if resp != nil {
+			return false, fmt.Errorf("could not connect to unifi event stream, status=%s error=%s", resp.Status, err)
+		}
+		return false, err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-quit:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	h.streaming.Store(site, true)
+	h.Logger.WithField("site", site).Info("connected to unifi event stream")
+
+	// the socket only sends what happens from now on, what is already
+	// notified is dropped by the dedup
+	if !h.catchUp(site, alarmsSince, eventsSince, siteAlarms, siteEvents, quit) {
+		return true, nil
+	}
+
+	for {
+		_, body, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+
+		message := model.UnifiStreamMessage{}
+		err = json.Unmarshal(body, &message)
+		if err != nil {
+			h.Logger.WithField("site", site).Debugf("could not decode unifi stream message, error=%s", err)
+			continue
+		}
+
+		switch message.Meta.Message {
+		case StreamMessageEvents:
+			unifiEvents := model.UnifiEvents{Meta: model.Meta{RC: message.Meta.RC}, SiteDescription: h.SiteDescription(site), Controller: h.Config.Name}
+			err = json.Unmarshal(message.Data, &unifiEvents.Events)
+			if err != nil {
+				h.Logger.WithField("site", site).Debugf("could not decode unifi stream events, error=%s", err)
+				continue
+			}
+			h.updateInventory(site, unifiEvents.Events)
+			unifiEvents.UnifiInventory = h.Inventory(site)
+			select {
+			case siteEvents <- model.UnifiSiteEvents{site: unifiEvents}:
+			case <-quit:
+				return true, nil
+			}
+		case StreamMessageAlarm:
+			unifiAlarms := model.UnifiAlarms{Meta: model.Meta{RC: message.Meta.RC}, SiteDescription: h.SiteDescription(site), Controller: h.Config.Name}
+			err = json.Unmarshal(message.Data, &unifiAlarms.Alarms)
+			if err != nil {
+				h.Logger.WithField("site", site).Debugf("could not decode unifi stream alarms, error=%s", err)
+				continue
+			}
+			unifiAlarms.UnifiInventory = h.Inventory(site)
+			select {
+			case siteAlarms <- model.UnifiSiteAlarms{site: unifiAlarms}:
+			case <-quit:
+				return true, nil
+			}
+		}
+	}
+}
+
+// catchUp polls a site once from its checkpoints and sends what it finds like
+// streamed alarms and events. It returns false when quit was closed.
+func (h *UnifiHandler) catchUp(site string, alarmsSince func(site string) time.Time, eventsSince func(site string) time.Time, siteAlarms chan<- model.UnifiSiteAlarms, siteEvents chan<- model.UnifiSiteEvents, quit <-chan struct{}) bool {
+	logger := h.Logger.WithField("site", site)
+	unifiAlarms, err := h.getSiteAlarms(site, alarmsSince(site))
+	if err != nil {
+		logger.Errorf("could not catch up on alarms, error=%s", err)
+	} else if len(unifiAlarms.Alarms) > 0 {
+		select {
+		case siteAlarms <- model.UnifiSiteAlarms{site: unifiAlarms}:
+		case <-quit:
+			return false
+		}
+	}
+
+	unifiEvents, err := h.getSiteEvents(site, eventsSince(site))
+	if err != nil {
+		logger.Errorf("could not catch up on events, error=%s", err)
+	} else if len(unifiEvents.Events) > 0 {
+		select {
+		case siteEvents <- model.UnifiSiteEvents{site: unifiEvents}:
+		case <-quit:
+			return false
+		}
+	}
+	return true
+}
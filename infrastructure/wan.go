@@ -0,0 +1,159 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ryancurrah/unifi-notifications/domain/model"
+)
+
+var gatewayTypes = []string{"ugw", "udm", "uxg"}
+
+// WANMonitorHandler compares the active WAN and public IP of every gateway with
+// the previous poll, multi-WAN gateways fail over without the controller
+// logging an event.
+type WANMonitorHandler struct {
+	Config   model.WANMonitorConfig
+	Logger   *logrus.Logger
+	gateways *gatewayStore
+}
+
+type gatewayStore struct {
+	sync.Mutex
+	uplinks map[string]*gatewayUplink
+}
+
+// gatewayUplink is the WAN a gateway used at the previous poll, since is when
+// it left the primary WAN. The public IP from the site health and the IP of
+// the uplink are kept apart, behind a modem or CGNAT they differ and the site
+// health is missing when it could not be fetched.
+type gatewayUplink struct {
+	wan      string
+	primary  bool
+	ip       string
+	uplinkIP string
+	since    time.Time
+}
+
+func NewWANMonitorHandler(config model.WANMonitorConfig, logger *logrus.Logger) WANMonitorHandler {
+	return WANMonitorHandler{Config: config, Logger: logger, gateways: &gatewayStore{uplinks: map[string]*gatewayUplink{}}}
+}
+
+// CompareUplinks returns an event for every gateway that failed over to
+// another WAN, failed back to the primary WAN or whose public IP changed since
+// the previous poll. The public IP and ISP are taken from the site health when
+// it has them, a public IP change is only reported between two IPs from the
+// same source.
+func (h *WANMonitorHandler) CompareUplinks(unifiSiteDevices model.UnifiSiteDevices, unifiSiteHealth model.UnifiSiteHealth, now time.Time) model.UnifiSiteEvents {
+	h.gateways.Lock()
+	defer h.gateways.Unlock()
+	unifiSiteEvents := model.UnifiSiteEvents{}
+	for site, unifiDevices := range unifiSiteDevices {
+		wan := siteWAN(unifiSiteHealth[site])
+		unifiEvents := model.UnifiEvents{SiteDescription: unifiDevices.SiteDescription, Controller: unifiDevices.Controller}
+		for _, unifiDevice := range unifiDevices.Devices {
+			if !containsString(gatewayTypes, unifiDevice.Type) || unifiDevice.State != model.DeviceStateConnected || unifiDevice.Uplink.Name == "" {
+				continue
+			}
+			mac := strings.ToLower(unifiDevice.Mac)
+			active, primary := activeWAN(unifiDevice)
+			ip := firstNonEmpty(wan.WanIP, unifiDevice.Uplink.IP)
+
+			id := fmt.Sprintf("%s/%s/%s", unifiDevices.Controller, site, mac)
+			previous, ok := h.gateways.uplinks[id]
+			if !ok {
+				h.gateways.uplinks[id] = &gatewayUplink{wan: active, primary: primary, ip: wan.WanIP, uplinkIP: unifiDevice.Uplink.IP, since: now}
+				continue
+			}
+			previousIP, ipChanged := previous.ipChange(wan.WanIP, unifiDevice.Uplink.IP)
+
+			gateway := mac
+			if unifiDevice.Name != "" {
+				gateway = fmt.Sprintf("%s (%s)", unifiDevice.Name, mac)
+			}
+			var unifiEvent model.UnifiEvent
+			switch {
+			case previous.wan != active && primary:
+				h.Logger.WithField("site", site).Infof("gateway %s failed back from %s to %s", mac, previous.wan, active)
+				unifiEvent = syntheticEvent(fmt.Sprintf("wan-%s-%d", mac, now.Unix()), model.EventKeyWANFailback, now,
+					fmt.Sprintf("Gateway %s is back on %s after the primary WAN was down for %s, public IP %s", gateway, active, humanDuration(now.Sub(previous.since)), publicIP(ip, wan.IspName)))
+				unifiEvent.Duration = int64(now.Sub(previous.since) / time.Second)
+			case previous.wan != active:
+				h.Logger.WithField("site", site).Warnf("gateway %s failed over from %s to %s", mac, previous.wan, active)
+				if previous.primary {
+					previous.since = now
+				}
+				unifiEvent = syntheticEvent(fmt.Sprintf("wan-%s-%d", mac, now.Unix()), model.EventKeyWANFailover, now,
+					fmt.Sprintf("Gateway %s failed over from %s to %s, public IP %s", gateway, previous.wan, active, publicIP(ip, wan.IspName)))
+			case ipChanged:
+				h.Logger.WithField("site", site).Infof("public ip of gateway %s changed from %s to %s", mac, previousIP, ip)
+				unifiEvent = syntheticEvent(fmt.Sprintf("ip-%s-%d", mac, now.Unix()), model.EventKeyPublicIPChanged, now,
+					fmt.Sprintf("Public IP of gateway %s on %s changed from %s to %s", gateway, active, previousIP, publicIP(ip, wan.IspName)))
+			}
+			if unifiEvent.ID != "" {
+				unifiEvent.Gw, unifiEvent.GwName = mac, unifiDevice.Name
+				unifiEvent.IP = ip
+				unifiEvents.Events = append(unifiEvents.Events, unifiEvent)
+			}
+
+			previous.wan, previous.primary = active, primary
+			if wan.WanIP != "" {
+				previous.ip = wan.WanIP
+			}
+			if unifiDevice.Uplink.IP != "" {
+				previous.uplinkIP = unifiDevice.Uplink.IP
+			}
+		}
+		if len(unifiEvents.Events) > 0 {
+			unifiSiteEvents[site] = unifiEvents
+		}
+	}
+	return unifiSiteEvents
+}
+
+// ipChange compares the public IP with the previous one from the site health
+// and falls back to the uplink IP only while the site health never had one.
+func (u *gatewayUplink) ipChange(healthIP string, uplinkIP string) (string, bool) {
+	switch {
+	case u.ip != "":
+		return u.ip, healthIP != "" && healthIP != u.ip
+	case healthIP != "":
+		return "", false
+	}
+	return u.uplinkIP, u.uplinkIP != "" && uplinkIP != "" && uplinkIP != u.uplinkIP
+}
+
+// activeWAN names the WAN the uplink of a gateway is on and whether it is the
+// primary WAN.
+func activeWAN(unifiDevice model.UnifiDevice) (string, bool) {
+	switch unifiDevice.Uplink.Name {
+	case unifiDevice.Wan1.IfName:
+		return fmt.Sprintf("WAN1 (%s)", unifiDevice.Uplink.Name), true
+	case unifiDevice.Wan2.IfName:
+		return fmt.Sprintf("WAN2 (%s)", unifiDevice.Uplink.Name), false
+	}
+	return unifiDevice.Uplink.Name, unifiDevice.Wan1.IfName == ""
+}
+
+func siteWAN(unifiHealth model.UnifiHealth) model.UnifiSubsystemHealth {
+	for _, subsystem := range unifiHealth.Subsystems {
+		if subsystem.Subsystem == "wan" {
+			return subsystem
+		}
+	}
+	return model.UnifiSubsystemHealth{}
+}
+
+func publicIP(ip string, isp string) string {
+	if ip == "" {
+		ip = "unknown"
+	}
+	if isp != "" {
+		return fmt.Sprintf("%s (%s)", ip, isp)
+	}
+	return ip
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	tls "crypto/tls"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -19,135 +18,417 @@ import (
 	"github.com/ryancurrah/unifi-notifications/domain/model"
 )
 
+const flushInterval = time.Minute
+
 var (
-	alarmsLastChecked = time.Now()
-	eventsLastChecked = time.Now()
-	alarmsQuitSignal  chan bool
-	eventsQuitSignal  chan bool
-	mainQuitSignal    chan os.Signal
-	wg                sync.WaitGroup
+	quitSignal     chan struct{}
+	mainQuitSignal chan os.Signal
+	wg             sync.WaitGroup
 )
 
 func main() {
 	defer wg.Wait()
-	alarmsQuitSignal = make(chan bool)
-	eventsQuitSignal = make(chan bool)
+	quitSignal = make(chan struct{})
 	mainQuitSignal = make(chan os.Signal, 1)
 	signal.Notify(mainQuitSignal, syscall.SIGINT, syscall.SIGTERM)
 
-	appConfig, loggerConfig, unifiConfig, slackConfig, err := model.NewConfig()
+	config, err := model.NewConfig()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	logger, err := infrastructure.NewLogHandler(loggerConfig)
+	logger, err := infrastructure.NewLogHandler(config.Logger)
 	if err != nil {
 		logger.Fatalf("logger handler setup failed, error=%s", err)
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	stateHandler, err := infrastructure.NewStateHandler(config.State, logger)
+	if err != nil {
+		logger.Fatalf("state handler setup failed, error=%s", err)
 	}
 
-	jar, err := cookiejar.New(nil)
+	nameHandler, err := infrastructure.NewNameHandler(config.Name, logger)
 	if err != nil {
-		logger.Fatalf("cookie jar setup failed, error=%s", err)
+		logger.Fatalf("name handler setup failed, error=%s", err)
+	}
+
+	hostHandler, err := infrastructure.NewHostHandler(config.Host, logger)
+	if err != nil {
+		logger.Fatalf("host handler setup failed, error=%s", err)
+	}
+
+	newClientHandler, err := infrastructure.NewNewClientHandler(config.Client, nameHandler, stateHandler, logger)
+	if err != nil {
+		logger.Fatalf("new client handler setup failed, error=%s", err)
+	}
+
+	geoIPHandler, err := infrastructure.NewGeoIPHandler(config.GeoIP, logger)
+	if err != nil {
+		logger.Fatalf("geoip handler setup failed, error=%s", err)
+	}
+
+	severityHandler, err := infrastructure.NewSeverityHandler(config.Severity, logger)
+	if err != nil {
+		logger.Fatalf("severity handler setup failed, error=%s", err)
+	}
+
+	filterHandler, err := infrastructure.NewFilterHandler(config.Filter, logger)
+	if err != nil {
+		logger.Fatalf("filter handler setup failed, error=%s", err)
 	}
-	httpClient := http.Client{Jar: jar, Transport: tr}
 
-	unifiHandler := infrastructure.NewUnifiHandler(unifiConfig, httpClient, logger)
+	scheduleHandler, err := infrastructure.NewScheduleHandler(config.Schedule, stateHandler, logger)
+	if err != nil {
+		logger.Fatalf("schedule handler setup failed, error=%s", err)
+	}
+
+	flapHandler := infrastructure.NewFlapHandler(config.Flap, logger)
+
+	throttleHandler := infrastructure.NewThrottleHandler(config.Throttle, logger)
+
+	templateHandler, err := infrastructure.NewTemplateHandler(config.Template, logger)
+	if err != nil {
+		logger.Fatalf("template handler setup failed, error=%s", err)
+	}
+
+	routeHandler, err := infrastructure.NewRouteHandler(config.Route, config.App.NotificationServices, config.Slack, throttleHandler, templateHandler, logger)
+	if err != nil {
+		logger.Fatalf("route handler setup failed, error=%s", err)
+	}
 
-	slackHandler := infrastructure.NewSlackHandler(slackConfig, logger)
+	digestHandler := infrastructure.NewDigestHandler(config.Digest, logger)
 
-	go checkAlarms(appConfig.CheckInterval, logger, unifiHandler, slackHandler)
-	go checkEvents(appConfig.CheckInterval, logger, unifiHandler, slackHandler, unifiConfig.Username)
+	mentionHandler, err := infrastructure.NewMentionHandler(config.Mention, logger)
+	if err != nil {
+		logger.Fatalf("mention handler setup failed, error=%s", err)
+	}
+
+	deviceMonitorHandler := infrastructure.NewDeviceMonitorHandler(config.Monitor, logger)
+	healthMonitorHandler := infrastructure.NewHealthMonitorHandler(config.Health, logger)
+	wanMonitorHandler := infrastructure.NewWANMonitorHandler(config.WAN, logger)
+
+	firmwareHandler, err := infrastructure.NewFirmwareHandler(config.Firmware, logger)
+	if err != nil {
+		logger.Fatalf("firmware handler setup failed, error=%s", err)
+	}
+
+	notificationHandler := infrastructure.NewNotificationHandler(nameHandler, hostHandler, newClientHandler, geoIPHandler, severityHandler, filterHandler, scheduleHandler, flapHandler, throttleHandler, digestHandler, mentionHandler, routeHandler, logger)
+
+	wg.Add(1)
+	go flushNotifications(logger, notificationHandler)
+
+	if config.Schedule.AdminListenAddr != "" {
+		adminHandler := infrastructure.NewAdminHandler(config.Schedule, scheduleHandler, logger)
+		wg.Add(1)
+		go serveAdmin(logger, adminHandler)
+	}
+
+	for _, unifiConfig := range config.Unifi {
+		unifiHandler, err := newUnifiHandler(unifiConfig, logger)
+		if err != nil {
+			logger.Fatalf("unifi handler setup failed, controller=%s error=%s", unifiConfig.Name, err)
+		}
+
+		wg.Add(2)
+		go checkAlarms(config.App.CheckInterval, logger, unifiHandler, stateHandler, notificationHandler)
+		go checkEvents(config.App.CheckInterval, logger, unifiHandler, stateHandler, notificationHandler, unifiConfig.Username)
+
+		monitors := deviceMonitors(config, logger, unifiHandler, deviceMonitorHandler, wanMonitorHandler, firmwareHandler, notificationHandler)
+		if len(monitors) > 0 {
+			wg.Add(1)
+			go monitorDevices(logger, unifiHandler, monitors)
+		}
+
+		if config.Health.Enabled {
+			wg.Add(1)
+			go monitorHealth(config.Health.Interval, logger, unifiHandler, healthMonitorHandler, notificationHandler)
+		}
+
+		if unifiConfig.Stream {
+			siteAlarms := make(chan model.UnifiSiteAlarms)
+			siteEvents := make(chan model.UnifiSiteEvents)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				unifiHandler.StreamSites(stateHandler.AlarmsSince(unifiHandler.Config.Name), stateHandler.EventsSince(unifiHandler.Config.Name), siteAlarms, siteEvents, quitSignal)
+			}()
+			go streamNotifications(logger, siteAlarms, siteEvents, stateHandler, notificationHandler, unifiConfig.Username)
+		}
+	}
 
 	logger.Info("started successfully")
 	for {
 		select {
 		case <-mainQuitSignal:
 			logger.Warn("received quit signal")
-			go func() {
-				alarmsQuitSignal <- true
-				logger.Info("alarms checker quit succesfully")
-			}()
+			close(quitSignal)
+			return
+		}
+	}
+}
 
-			go func() {
-				eventsQuitSignal <- true
-				logger.Info("events checker quit succesfully")
-			}()
+// newUnifiHandler gives every controller its own transport and cookie jar so
+// sessions are never shared between controllers.
+func newUnifiHandler(unifiConfig model.UnifiConfig, logger *logrus.Logger) (infrastructure.UnifiHandler, error) {
+	tlsConfig, err := infrastructure.NewTLSConfig(unifiConfig)
+	if err != nil {
+		return infrastructure.UnifiHandler{}, fmt.Errorf("tls setup failed, error=%s", err)
+	}
+	if unifiConfig.InsecureSkipVerify {
+		logger.WithField("controller", unifiConfig.Name).Warn("unifi controller certificate verification is disabled")
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return infrastructure.UnifiHandler{}, fmt.Errorf("cookie jar setup failed, error=%s", err)
+	}
+	httpClient := http.Client{Jar: jar, Transport: tr}
+
+	return infrastructure.NewUnifiHandler(unifiConfig, httpClient, logger), nil
+}
+
+func checkAlarms(checkInterval int, logger *logrus.Logger, unifiHandler infrastructure.UnifiHandler, stateHandler infrastructure.StateHandler, notificationHandler infrastructure.NotificationHandler) {
+	defer wg.Done()
+	for {
+		select {
+		case <-time.After(time.Duration(checkInterval)*time.Minute + time.Duration(rand.Intn(30-1)+1)*time.Second):
+			logger.WithField("controller", unifiHandler.Config.Name).Info("checking for new alarms")
+			siteAlarms, err := unifiHandler.GetAlarms(stateHandler.AlarmsSince(unifiHandler.Config.Name))
+			if err != nil {
+				logger.Error(err)
+			}
+
+			siteAlarms = stateHandler.FilterAlarms(siteAlarms)
+
+			notifyAlarms(logger, notificationHandler, siteAlarms)
+
+			stateHandler.CheckpointAlarms(siteAlarms)
+		case <-quitSignal:
+			logger.WithField("controller", unifiHandler.Config.Name).Info("alarms checker quit succesfully")
 			return
 		}
 	}
 }
 
-func checkAlarms(checkInterval int, logger *logrus.Logger, unifiHandler infrastructure.UnifiHandler, slackHandler infrastructure.SlackHandler) {
-	wg.Add(1)
+func checkEvents(checkInterval int, logger *logrus.Logger, unifiHandler infrastructure.UnifiHandler, stateHandler infrastructure.StateHandler, notificationHandler infrastructure.NotificationHandler, username string) {
 	defer wg.Done()
 	for {
 		select {
 		case <-time.After(time.Duration(checkInterval)*time.Minute + time.Duration(rand.Intn(30-1)+1)*time.Second):
-			logger.Infof("checking for new alarms since %s", alarmsLastChecked.String())
-			siteAlarms, err := unifiHandler.GetAlarms(alarmsLastChecked)
+			logger.WithField("controller", unifiHandler.Config.Name).Info("checking for new events")
+			siteEvents, err := unifiHandler.GetEvents(stateHandler.EventsSince(unifiHandler.Config.Name))
 			if err != nil {
 				logger.Error(err)
 			}
 
-			for _, unifiAlarms := range siteAlarms {
-				for _, unifiAlarm := range unifiAlarms.Alarms {
-					logger.WithField("type", "alarm").Infof("%s %s", unifiAlarm.Msg, unifiAlarm.Datetime.String())
+			siteEvents = stateHandler.FilterEvents(siteEvents)
+
+			notifyEvents(logger, notificationHandler, filterAdminLoginEvents(username, siteEvents))
+
+			stateHandler.CheckpointEvents(siteEvents)
+		case <-quitSignal:
+			logger.WithField("controller", unifiHandler.Config.Name).Info("events checker quit succesfully")
+			return
+		}
+	}
+}
+
+// deviceMonitor compares the devices of a controller every interval, next is
+// when it is due again.
+type deviceMonitor struct {
+	interval time.Duration
+	next     time.Time
+	compare  func(siteDevices model.UnifiSiteDevices, now time.Time)
+}
+
+// monitorDevices fetches the devices of a controller once per tick and passes
+// them to the monitors that are due, the tick is the shortest monitor interval.
+func monitorDevices(logger *logrus.Logger, unifiHandler infrastructure.UnifiHandler, monitors []*deviceMonitor) {
+	defer wg.Done()
+	interval := monitors[0].interval
+	for _, monitor := range monitors[1:] {
+		if monitor.interval < interval {
+			interval = monitor.interval
+		}
+	}
+	for {
+		select {
+		case <-time.After(interval):
+			now := time.Now()
+			due := []*deviceMonitor{}
+			for _, monitor := range monitors {
+				if !now.Before(monitor.next) {
+					due = append(due, monitor)
 				}
 			}
+			if len(due) == 0 {
+				continue
+			}
 
-			err = slackHandler.NotifyAlarms(siteAlarms)
+			logger.WithField("controller", unifiHandler.Config.Name).Debug("checking devices")
+			siteDevices, err := unifiHandler.GetDevices()
 			if err != nil {
 				logger.Error(err)
+				continue
 			}
 
-			alarmsLastChecked = time.Now()
-		case <-alarmsQuitSignal:
+			for _, monitor := range due {
+				monitor.next = now.Add(monitor.interval)
+				monitor.compare(siteDevices, now)
+			}
+		case <-quitSignal:
+			logger.WithField("controller", unifiHandler.Config.Name).Info("device monitor quit succesfully")
 			return
 		}
 	}
 }
 
-func checkEvents(checkInterval int, logger *logrus.Logger, unifiHandler infrastructure.UnifiHandler, slackHandler infrastructure.SlackHandler, username string) {
-	wg.Add(1)
+// deviceMonitors returns the enabled monitors comparing the devices of a
+// controller.
+func deviceMonitors(config model.Config, logger *logrus.Logger, unifiHandler infrastructure.UnifiHandler, deviceMonitorHandler infrastructure.DeviceMonitorHandler, wanMonitorHandler infrastructure.WANMonitorHandler, firmwareHandler infrastructure.FirmwareHandler, notificationHandler infrastructure.NotificationHandler) []*deviceMonitor {
+	monitors := []*deviceMonitor{}
+	if config.Monitor.Enabled {
+		monitors = append(monitors, &deviceMonitor{interval: config.Monitor.Interval, compare: func(siteDevices model.UnifiSiteDevices, now time.Time) {
+			siteEvents := deviceMonitorHandler.CompareDevices(siteDevices, now)
+			if len(siteEvents) > 0 {
+				notifyEvents(logger, notificationHandler, siteEvents)
+			}
+		}})
+	}
+
+	if config.WAN.Enabled {
+		monitors = append(monitors, &deviceMonitor{interval: config.WAN.Interval, compare: func(siteDevices model.UnifiSiteDevices, now time.Time) {
+			siteHealth, err := unifiHandler.GetHealth()
+			if err != nil {
+				logger.Error(err)
+			}
+
+			siteEvents := wanMonitorHandler.CompareUplinks(siteDevices, siteHealth, now)
+			if len(siteEvents) > 0 {
+				notifyEvents(logger, notificationHandler, siteEvents)
+			}
+		}})
+	}
+
+	if config.Firmware.Enabled {
+		monitors = append(monitors, &deviceMonitor{interval: config.Firmware.Interval, compare: func(siteDevices model.UnifiSiteDevices, now time.Time) {
+			sysinfo, err := unifiHandler.GetSysinfo()
+			if err != nil {
+				logger.Error(err)
+			}
+
+			siteEvents := firmwareHandler.CompareFirmware(unifiHandler.Config.Name, siteDevices, sysinfo, now)
+			for _, unifiSiteEvents := range append(siteEvents, firmwareHandler.Summary(now)...) {
+				notifyEvents(logger, notificationHandler, unifiSiteEvents)
+			}
+		}})
+	}
+	return monitors
+}
+
+func monitorHealth(interval time.Duration, logger *logrus.Logger, unifiHandler infrastructure.UnifiHandler, healthMonitorHandler infrastructure.HealthMonitorHandler, notificationHandler infrastructure.NotificationHandler) {
 	defer wg.Done()
 	for {
 		select {
-		case <-time.After(time.Duration(checkInterval)*time.Minute + time.Duration(rand.Intn(30-1)+1)*time.Second):
-			logger.Infof("checking for new events since %s", eventsLastChecked.String())
-			siteEvents, err := unifiHandler.GetEvents(eventsLastChecked)
+		case <-time.After(interval):
+			logger.WithField("controller", unifiHandler.Config.Name).Debug("checking site health")
+			siteHealth, err := unifiHandler.GetHealth()
 			if err != nil {
 				logger.Error(err)
+				continue
 			}
 
-			siteEvents = filterAdminLoginEvents(username, siteEvents)
-
-			for _, unifiEvents := range siteEvents {
-				for _, unifiEvent := range unifiEvents.Events {
-					logger.WithField("type", "event").Infof("%s %s", unifiEvent.Msg, unifiEvent.Datetime.String())
-				}
+			siteEvents := healthMonitorHandler.CompareHealth(siteHealth, time.Now())
+			if len(siteEvents) > 0 {
+				notifyEvents(logger, notificationHandler, siteEvents)
 			}
+		case <-quitSignal:
+			logger.WithField("controller", unifiHandler.Config.Name).Info("health monitor quit succesfully")
+			return
+		}
+	}
+}
 
-			err = slackHandler.NotifyEvents(siteEvents)
+func streamNotifications(logger *logrus.Logger, siteAlarms <-chan model.UnifiSiteAlarms, siteEvents <-chan model.UnifiSiteEvents, stateHandler infrastructure.StateHandler, notificationHandler infrastructure.NotificationHandler, username string) {
+	defer wg.Done()
+	for {
+		select {
+		case unifiSiteAlarms := <-siteAlarms:
+			unifiSiteAlarms = stateHandler.FilterAlarms(unifiSiteAlarms)
+			notifyAlarms(logger, notificationHandler, unifiSiteAlarms)
+			stateHandler.CheckpointAlarms(unifiSiteAlarms)
+		case unifiSiteEvents := <-siteEvents:
+			unifiSiteEvents = stateHandler.FilterEvents(unifiSiteEvents)
+			notifyEvents(logger, notificationHandler, filterAdminLoginEvents(username, unifiSiteEvents))
+			stateHandler.CheckpointEvents(unifiSiteEvents)
+		case <-quitSignal:
+			logger.Info("stream notifier quit succesfully")
+			return
+		}
+	}
+}
+
+func flushNotifications(logger *logrus.Logger, notificationHandler infrastructure.NotificationHandler) {
+	defer wg.Done()
+	for {
+		select {
+		case <-time.After(flushInterval):
+			err := notificationHandler.Flush(false)
 			if err != nil {
 				logger.Error(err)
 			}
-
-			eventsLastChecked = time.Now()
-		case <-eventsQuitSignal:
+		case <-quitSignal:
+			err := notificationHandler.Flush(true)
+			if err != nil {
+				logger.Error(err)
+			}
+			logger.Info("notification flusher quit succesfully")
 			return
 		}
 	}
 }
 
+func serveAdmin(logger *logrus.Logger, adminHandler infrastructure.AdminHandler) {
+	defer wg.Done()
+	go func() {
+		<-quitSignal
+		err := adminHandler.Shutdown()
+		if err != nil {
+			logger.Error(err)
+		}
+	}()
+	err := adminHandler.ListenAndServe()
+	if err != nil {
+		logger.Errorf("admin api failed, error=%s", err)
+		return
+	}
+	logger.Info("admin api quit succesfully")
+}
+
+func notifyAlarms(logger *logrus.Logger, notificationHandler infrastructure.NotificationHandler, siteAlarms model.UnifiSiteAlarms) {
+	err := notificationHandler.NotifyAlarms(siteAlarms)
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+func notifyEvents(logger *logrus.Logger, notificationHandler infrastructure.NotificationHandler, siteEvents model.UnifiSiteEvents) {
+	err := notificationHandler.NotifyEvents(siteEvents)
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
 func filterAdminLoginEvents(adminName string, unifiSiteEvents model.UnifiSiteEvents) model.UnifiSiteEvents {
 	filteredUnifiSiteEvents := model.UnifiSiteEvents{}
 	for site, unifiEvents := range unifiSiteEvents {
-		filteredUnifiEvents := model.UnifiEvents{}
+		filteredUnifiEvents := unifiEvents
+		filteredUnifiEvents.Events = nil
 		for _, unifiEvent := range unifiEvents.Events {
 			if !strings.HasPrefix(unifiEvent.Msg, fmt.Sprintf("Admin[%s] log in from", adminName)) {
 				filteredUnifiEvents.Events = append(filteredUnifiEvents.Events, unifiEvent)